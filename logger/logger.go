@@ -11,6 +11,16 @@ const (
 	jobFinished = "job-finished"
 )
 
+// Statuses of job-finished events, which tell failures apart so they can be alerted on differently
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	// StatusDataNotArrived is for jobs that gave up waiting for their input data
+	StatusDataNotArrived = "data-not-arrived"
+	// StatusAlreadyLoaded is for jobs whose input files had all been loaded before, so were skipped
+	StatusAlreadyLoaded = "already-loaded"
+)
+
 func init() {
 	log = logger.New("s3-to-redshift")
 }
@@ -31,12 +41,37 @@ func SetGlobalRouting(kvconfigPath string) error {
 // JobFinishedEvent logs when s3-to-redshift has completed
 // along with payload and success/failure
 func JobFinishedEvent(payload string, didSucceed bool) {
+	JobFinishedEventWithData(payload, didSucceed, nil)
+}
+
+// JobFinishedEventWithData is JobFinishedEvent with extra fields attached,
+// such as the rows redshift rejected during a failed load, or a status
+// other than succeeded or failed
+func JobFinishedEventWithData(payload string, didSucceed bool, data M) {
 	value := 0
 	if didSucceed {
 		value = 1
 	}
-	log.GaugeIntD(jobFinished, value, M{
-		"payload": payload,
-		"success": didSucceed,
+	fields := M{}
+	for k, v := range data {
+		fields[k] = v
+	}
+	fields["payload"] = payload
+	fields["success"] = didSucceed
+	if _, ok := fields["status"]; !ok {
+		fields["status"] = StatusFailed
+		if didSucceed {
+			fields["status"] = StatusSucceeded
+		}
+	}
+	log.GaugeIntD(jobFinished, value, fields)
+}
+
+// DataNotArrivedEvent logs when s3-to-redshift failed because its input data
+// didn't arrive in time, rather than because something went wrong
+func DataNotArrivedEvent(payload string, err error) {
+	JobFinishedEventWithData(payload, false, M{
+		"status": StatusDataNotArrived,
+		"error":  err.Error(),
 	})
 }
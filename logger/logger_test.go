@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"errors"
 	l "log"
 	"testing"
 
@@ -49,3 +50,33 @@ func TestJobFinished(t *testing.T) {
 		assert.Equal(counts[test.rule], 1)
 	}
 }
+
+// TestJobFinishedWithData verifies that JobFinishedEventWithData
+// log routes to the 'job-finished' rule as well
+func TestJobFinishedWithData(t *testing.T) {
+	assert := assert.New(t)
+
+	mocklog := logger.NewMockCountLogger("s3-to-redshift")
+	log = mocklog // Overrides package level logger
+
+	JobFinishedEventWithData("--schema api --tables business_metrics_auth_counts", false, M{
+		"error": "load failed",
+	})
+	counts := mocklog.RuleCounts()
+
+	assert.Equal(counts["job-finished"], 1)
+}
+
+// TestDataNotArrived verifies that DataNotArrivedEvent
+// log routes to the 'job-finished' rule as well
+func TestDataNotArrived(t *testing.T) {
+	assert := assert.New(t)
+
+	mocklog := logger.NewMockCountLogger("s3-to-redshift")
+	log = mocklog // Overrides package level logger
+
+	DataNotArrivedEvent("--schema api --tables business_metrics_auth_counts", errors.New("s3 file not found"))
+	counts := mocklog.RuleCounts()
+
+	assert.Equal(counts["job-finished"], 1)
+}
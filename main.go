@@ -3,26 +3,30 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Clever/analytics-util/analyticspipeline"
 	discovery "github.com/Clever/discovery-go"
+	"github.com/Clever/pathio"
 	"github.com/Clever/s3-to-redshift/v3/logger"
 	redshift "github.com/Clever/s3-to-redshift/v3/redshift"
 	s3filepath "github.com/Clever/s3-to-redshift/v3/s3filepath"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/kardianos/osext"
 	env "github.com/segmentio/go-env"
@@ -85,19 +89,65 @@ func getMapKeys(m map[string]bool) []string {
 	return keys
 }
 
-// Rounds down a dateTime to a granularity
+// granularities are the periods of time a table's data date windows can span, besides stream
+var granularities = map[string]bool{
+	"minute": true, "15min": true, "hour": true, "day": true, "week": true, "month": true, "quarter": true,
+}
+
+// Rounds down a dateTime to the start of its granularity period
 // For instance, 11:50AM will be truncated to 11:00AM
-// if given a granularity of an hour
+// if given a granularity of an hour. Weeks start on Monday.
 func truncateDate(date time.Time, granularity string) time.Time {
+	y, m, d := date.Date()
+	loc := date.Location()
 	switch granularity {
+	case "minute":
+		return time.Date(y, m, d, date.Hour(), date.Minute(), 0, 0, loc)
+	case "15min":
+		return time.Date(y, m, d, date.Hour(), date.Minute()-date.Minute()%15, 0, 0, loc)
 	case "hour":
-		return date.Truncate(time.Hour)
+		return time.Date(y, m, d, date.Hour(), 0, 0, 0, loc)
+	case "week":
+		return time.Date(y, m, d-(int(date.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case "quarter":
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, loc)
 	default:
 		// Round down to day granularity by default
-		return date.Truncate(24 * time.Hour)
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
 	}
 }
 
+// nextPeriod returns the start of the granularity period after the one starting at start
+func nextPeriod(start time.Time, granularity string) time.Time {
+	y, m, d := start.Date()
+	loc := start.Location()
+	switch granularity {
+	case "minute":
+		return time.Date(y, m, d, start.Hour(), start.Minute()+1, 0, 0, loc)
+	case "15min":
+		return time.Date(y, m, d, start.Hour(), start.Minute()+15, 0, 0, loc)
+	case "hour":
+		return time.Date(y, m, d, start.Hour()+1, 0, 0, 0, loc)
+	case "week":
+		return time.Date(y, m, d+7, 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+	case "quarter":
+		return time.Date(y, m+3, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	}
+}
+
+// wallClock returns the wall clock time of t in loc as UTC, which is how Redshift hands back
+// timestamps without time zone holding times in loc
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
 // Calculates whether or not input data (s3) is more stale than target data (Redshift)
 // Expects:
 // - inputDataDate corresponds to the s3 data timestamp of the job
@@ -111,46 +161,82 @@ func isInputDataStale(inputDataDate time.Time, targetDataDate *time.Time,
 		return false
 	}
 
-	// Handle comparison for target data in a different time zone (ex. PT)
-	_, offsetSec := targetDataDate.In(targetDataLoc).Zone()
-	offsetDuration, err := time.ParseDuration(fmt.Sprintf("%vs", -1*offsetSec))
-	fatalIfErr(err, "isInputDataStale was unable to parse offset duration")
-
-	*targetDataDate = targetDataDate.Add(offsetDuration)
+	// Handle comparison for target data in a different time zone (ex. PT): Redshift hands back
+	// timestamps without time zone as UTC, so read it as wall clock time in the target's zone instead
+	t := *targetDataDate
+	*targetDataDate = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), targetDataLoc).UTC()
 
 	// We truncate the timestamps to make the comparison at the correct granularity
 	// i.e. input data lagging by two hours is considered stale when granularity is hourly,
 	// but it can still be considered fresh when the granularity is daily.
-	return truncateDate(*targetDataDate, granularity).After(truncateDate(inputDataDate, granularity))
+	return truncateDate(*targetDataDate, granularity).After(truncateDate(inputDataDate.UTC(), granularity))
 }
 
-// getRegionForBucket looks up the region name for the given bucket
-func getRegionForBucket(name string) (string, error) {
-	// Any region will work for the region lookup, but the request MUST use
-	// PathStyle
-	config := aws.NewConfig().WithRegion("us-west-1").WithS3ForcePathStyle(true)
-	session := session.New()
-	client := s3.New(session, config)
-	params := s3.GetBucketLocationInput{
-		Bucket: aws.String(name),
-	}
-	resp, err := client.GetBucketLocation(&params)
-	if err != nil {
-		return "", fmt.Errorf("failed to get location for bucket '%s', %s", name, err)
-	}
-	if resp.LocationConstraint == nil {
-		// "US Standard", returns an empty region. So return any region in the US
-		return "us-east-1", nil
+// vacuumJob is the job posted to the cleanup worker after a load
+type vacuumJob struct {
+	Worker  string            `json:"worker"`
+	Payload map[string]string `json:"payload"`
+}
+
+// tablePlan is what a dry run would have done to a table
+type tablePlan struct {
+	Schema     string                      `json:"schema"`
+	Table      string                      `json:"table"`
+	Skipped    string                      `json:"skipped,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+	Statements []redshift.PlannedStatement `json:"statements"`
+	// Staged are the files the load would have written to the bucket's staging prefix
+	Staged []string   `json:"staged,omitempty"`
+	Vacuum *vacuumJob `json:"vacuum,omitempty"`
+}
+
+// dryRunWriter records the files a dry run would have written to the bucket, for its plan,
+// instead of writing them
+type dryRunWriter struct {
+	paths []string
+}
+
+func (w *dryRunWriter) Write(path string, data []byte) error {
+	log.Printf("Dry run, not writing %s", path)
+	w.paths = append(w.paths, path)
+	return nil
+}
+
+func newVacuumJob(schema, table string) vacuumJob {
+	return vacuumJob{
+		Worker: cleanupWorker,
+		// N.B. We need to pass backslashes to escape the quotation marks as required
+		// by Golang's os.Args for command line arguments
+		Payload: map[string]string{
+			"targets":     schema + `."` + table + `"`,
+			"vacuum_mode": "delete",
+			// If we truncated, analyze will run regardless since 100% of the rows have changed. Otherwise,
+			// only analyze if we've changed enough rows (threshold > 1%)
+			"analyze_mode":      "full",
+			"analyze_threshold": "1",
+		},
 	}
-	return *resp.LocationConstraint, nil
 }
 
 // in a transaction, truncate, create or update, and then copy from the s3 data file or manifest
 // yell loudly if there is anything different in the target table compared to config (different distkey, etc)
+// Varchars that can be widened in place are widened just before the transaction, and stay wider if it fails.
+// The load is recorded in the ledger with what it deleted and loaded, added to rec. Stream loads
+// replace the stream window, and move the table's watermark to its end.
+// On a dry run the transaction is rolled back and no vacuum is posted.
 func runCopy(
 	db *redshift.Redshift, inputConf s3filepath.S3File, inputTable redshift.Table, targetTable *redshift.Table,
-	truncate, gzip bool, delimiter, timeGranularity, targetTimeZone, streamStart, streamEnd string,
+	truncate, gzip bool, delimiter, timeGranularity string, targetLoc *time.Location, stream *streamWindow, mode string, dryRun bool,
+	rec redshift.LoadRecord,
 ) error {
+	started := time.Now()
+	// redshift won't widen a column in place in a transaction block, and doing it once the transaction
+	// below holds its locks would wait on them forever
+	if targetTable != nil {
+		if err := db.WidenColumns(inputConf, inputTable, *targetTable); err != nil {
+			return fmt.Errorf("err widening columns: %s", err)
+		}
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -159,36 +245,34 @@ func runCopy(
 	// TRUNCATE for dimension tables, but not fact tables
 	if truncate && targetTable != nil {
 		log.Println("truncating table!")
-		if err := db.Truncate(tx, inputConf.Schema, inputTable.Name); err != nil {
+		if rec.RowsDeleted, err = db.Truncate(tx, inputConf.Schema, inputTable.Name); err != nil {
 			return fmt.Errorf("err running truncate table: %s", err)
 		}
+		rec.Truncated = true
 	}
 	if targetTable == nil {
 		if err := db.CreateTable(tx, inputTable); err != nil {
 			return fmt.Errorf("err running create table: %s", err)
 		}
 	} else {
-		var start, end time.Time
-		var err error
-		if timeGranularity == "stream" {
-			start, err = time.Parse("2006-01-02T15:04:05", streamStart)
-			if err != nil {
-				return err
+		// merging replaces rows by primary key, so there's no time range to clear out
+		if mode != redshift.ModeMerge {
+			var start, end time.Time
+			var err error
+			if stream != nil {
+				start, end = stream.Start, stream.End
+			} else {
+				start, end = startEndFromGranularity(inputConf.DataDate, timeGranularity, targetLoc)
 			}
-			end, err = time.Parse("2006-01-02T15:04:05", streamEnd)
-			if err != nil {
-				return err
+			// To prevent duplicates, clear away any existing data within a certain time range as the data date
+			// (that is, sharing the same data date up to a certain time granularity)
+			if rec.RowsDeleted, err = db.TruncateInTimeRange(tx, inputConf.Schema, inputTable.Name, inputTable.Meta.DataDateColumn, start, end); err != nil {
+				return fmt.Errorf("err truncating data for data refresh: %s", err)
 			}
-		} else {
-			start, end = startEndFromGranularity(inputConf.DataDate, timeGranularity, targetTimeZone)
-		}
-		// To prevent duplicates, clear away any existing data within a certain time range as the data date
-		// (that is, sharing the same data date up to a certain time granularity)
-		if err := db.TruncateInTimeRange(tx, inputConf.Schema, inputTable.Name, inputTable.Meta.DataDateColumn, start, end); err != nil {
-			return fmt.Errorf("err truncating data for data refresh: %s", err)
+			rec.WindowStart, rec.WindowEnd = &start, &end
 		}
 
-		if err := db.UpdateTable(tx, inputTable, *targetTable); err != nil {
+		if err := db.UpdateTable(tx, inputConf, inputTable, *targetTable); err != nil {
 			return fmt.Errorf("err running update table: %s", err)
 		}
 	}
@@ -197,40 +281,59 @@ func runCopy(
 	// can't switch on file ending as manifest files b/c
 	// manifest files obscure the underlying file types
 	// instead just pass the delimiter along even if it's null
-	if err := db.Copy(tx, inputConf, delimiter, true, gzip); err != nil {
-		return fmt.Errorf("err running copy: %s", err)
+	if mode == redshift.ModeMerge {
+		if err := db.Merge(tx, inputConf, inputTable, delimiter, true, gzip); err != nil {
+			return fmt.Errorf("err running merge: %w", err)
+		}
+	} else if err := db.Copy(tx, inputConf, inputTable.Meta.Copy, delimiter, true, gzip); err != nil {
+		return fmt.Errorf("err running copy: %w", err)
+	}
+
+	rec.DataDateColumn = inputTable.Meta.DataDateColumn
+	// merges COPY into a staging table first, which is still the last COPY
+	if rec.RowsLoaded, err = db.LastCopyCount(tx); err != nil {
+		return err
+	}
+	rec.Duration = time.Since(started)
+	if err := db.RecordLoad(tx, rec); err != nil {
+		return err
+	}
+	// the next stream load starts where this one ended
+	if stream != nil {
+		if err := db.SetWatermark(tx, inputConf.Schema, inputTable.Name, stream.End, rec.RunID); err != nil {
+			return err
+		}
 	}
 
 	// Update the latency info table so we have an easier record of the last update.
-	if err := db.UpdateLatencyInfo(tx, *targetTable); err != nil {
+	// The target table may not have existed before, so go by the input table
+	if err := db.UpdateLatencyInfo(tx, inputTable); err != nil {
 		return fmt.Errorf("err updating latency info: %s", err)
 	}
 
+	if dryRun {
+		return tx.Rollback()
+	}
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("err committing transaction: %s", err)
 	}
 
 	// There's a good chance we've deleted some data in the table here (e.g. a stream load,
 	// truncate, or update historical set that exists). Run a vacuum to clear out the old data.
-	// Only one vacuum can be run at a time, so we're going to throw this over the wall to
-	// redshift-vacuum and use gearman-admin as a queueing service.
+	postVacuumJob(inputConf.Schema, inputTable.Name)
+	return nil
+}
+
+// postVacuumJob posts a vacuum of the table to the cleanup worker. Only one vacuum can be run at a
+// time, so we're going to throw this over the wall to redshift-vacuum and use gearman-admin as a
+// queueing service.
+func postVacuumJob(schema, table string) {
 	if len(gearmanAdminURL) == 0 {
 		log.Fatalf("Unable to post vacuum-analyze job to %s", cleanupWorker)
 	} else {
 		log.Println("Submitting job to Gearman admin")
 
-		// N.B. We need to pass backslashes to escape the quotation marks as required
-		// by Golang's os.Args for command line arguments
-		cleanupArgs := map[string]string{
-			"targets":     inputConf.Schema + `."` + inputTable.Name + `"`,
-			"vacuum_mode": "delete",
-			// If we truncated, analyze will run regardless since 100% of the rows have changed. Otherwise,
-			// only analyze if we've changed enough rows (threshold > 1%)
-			"analyze_mode":      "full",
-			"analyze_threshold": "1",
-		}
-
-		payload, err := json.Marshal(cleanupArgs)
+		payload, err := json.Marshal(newVacuumJob(schema, table).Payload)
 		if err != nil {
 			log.Fatalf("Error creating new payload: %s", err)
 		}
@@ -247,42 +350,582 @@ func runCopy(
 			log.Fatalf("Error submitting job: %s", err)
 		}
 	}
+}
+
+// checkRollback fails for loads that deleting their time window wouldn't undo: ones without a
+// window, ones that truncated the table first, and, unless forced, ones that a later load of the
+// same window replaced, whose rows the DELETE would take with it
+func checkRollback(bad redshift.LoadRecord, later *redshift.LoadRecord, force bool) error {
+	if bad.WindowStart == nil || bad.WindowEnd == nil {
+		return fmt.Errorf("the load of %s.%s by run %s merged into or created the table, so there's no time window to roll back",
+			bad.Schema, bad.Table, bad.RunID)
+	}
+	if bad.Truncated {
+		return fmt.Errorf("the load of %s.%s by run %s truncated the table, which deleting its time window wouldn't undo",
+			bad.Schema, bad.Table, bad.RunID)
+	}
+	if later != nil && !force {
+		return fmt.Errorf("the load of %s.%s by run %s was replaced by run %s's load of the same time window, "+
+			"which rolling it back would delete, pass force to roll it back anyway", bad.Schema, bad.Table, bad.RunID, later.RunID)
+	}
 	return nil
 }
 
-func startEndFromGranularity(t time.Time, granularity string, targetTimezone string) (time.Time, time.Time) {
-	// Rotate time if in PT
-	log.Print(targetTimezone)
-	if targetTimezone != "UTC" {
-		ptLoc, err := time.LoadLocation(targetTimezone)
-		fatalIfErr(err, "startEndFromGranularity was unable to load timezone")
+// rollback undoes the latest load of the payload's table by the payload's runId, or else of its date,
+// by deleting the time window the load replaced. With reload, the input file of the previous load of
+// that window is loaded again. Both happen in one transaction, which records the rollback in the ledger
+// and the latencies table. On a dry run the transaction is rolled back, no vacuum is posted, and the
+// files a reload would stage are recorded in staged instead of written.
+func rollback(db *redshift.Redshift, flags payload, runID string, staged *dryRunWriter) error {
+	if flags.InputTables == "" || strings.Contains(flags.InputTables, ",") {
+		return fmt.Errorf("rollback takes a single table, got %q", flags.InputTables)
+	}
+	if (flags.RunID == "") == (flags.DataDate == "") {
+		return fmt.Errorf("rollback takes either a date or a runId")
+	}
+	var date time.Time
+	if flags.DataDate != "" {
+		var err error
+		if date, err = time.Parse(time.RFC3339, flags.DataDate); err != nil {
+			return fmt.Errorf("issue parsing date %s: %s", flags.DataDate, err)
+		}
+	}
+	schema, table := flags.InputSchemaName, flags.InputTables
+	bad, err := db.FindLoad(schema, table, flags.RunID, date)
+	if err != nil {
+		return err
+	}
+	var later *redshift.LoadRecord
+	if bad.WindowStart != nil && bad.WindowEnd != nil && !bad.Truncated {
+		if later, err = db.LaterLoad(*bad); err != nil {
+			return err
+		}
+	}
+	if err := checkRollback(*bad, later, flags.Force); err != nil {
+		return err
+	}
+	log.Printf("rolling back the load of %s into %s.%s by run %s", bad.Source, schema, table, bad.RunID)
 
-		_, ptOffsetSec := t.In(ptLoc).Zone()
-		ptOffsetDuration, err := time.ParseDuration(fmt.Sprintf("%vs", ptOffsetSec))
-		fatalIfErr(err, "startEndFromGranularity was unable to parse offset duration")
+	rec := redshift.LoadRecord{
+		RunID:          runID,
+		Operation:      redshift.OperationRollback,
+		Schema:         schema,
+		Table:          table,
+		DataDate:       bad.DataDate,
+		Granularity:    bad.Granularity,
+		DataDateColumn: bad.DataDateColumn,
+		WindowStart:    bad.WindowStart,
+		WindowEnd:      bad.WindowEnd,
+		RolledBack:     bad.RunID,
+	}
+	var reloadConf *s3filepath.S3File
+	var reloadTable *redshift.Table
+	if flags.Reload {
+		previous, err := db.PreviousLoad(*bad)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			return fmt.Errorf("there's no previous load of %s.%s from %s to %s to reload", schema, table, bad.WindowStart, bad.WindowEnd)
+		}
+		bucket, storage, err := openBucket(flags)
+		if err != nil {
+			return err
+		}
+		if err := checkLoadable(bucket, flags.DryRun); err != nil {
+			return err
+		}
+		if reloadConf, err = s3filepath.SourceS3File(storage, bucket, schema, table, flags.ConfigFile, previous.DataDate, previous.Source); err != nil {
+			return err
+		}
+		if reloadTable, err = db.GetTableFromConf(*reloadConf); err != nil {
+			return err
+		}
+		if err := redshift.CheckCopyOptionsFor(reloadTable.Meta.Copy, reloadConf.Format, flags.Delimiter); err != nil {
+			return err
+		}
+		var writer s3filepath.FileWriter = storage
+		if flags.DryRun {
+			writer = staged
+		}
+		if err := stageJSONPaths(writer, reloadConf, *reloadTable, flags.Delimiter); err != nil {
+			return err
+		}
+		loaded, err := newLoadRecord(storage, runID, *reloadConf, flags)
+		if err != nil {
+			return err
+		}
+		rec.Source, rec.Bytes, rec.ConfigHash, rec.Files = loaded.Source, loaded.Bytes, loaded.ConfigHash, loaded.Files
+		log.Printf("reloading %s, loaded by run %s", previous.Source, previous.RunID)
+	}
 
-		t = t.Add(ptOffsetDuration)
+	started := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if rec.RowsDeleted, err = db.TruncateInTimeRange(tx, schema, table, bad.DataDateColumn, *bad.WindowStart, *bad.WindowEnd); err != nil {
+		return fmt.Errorf("err deleting the rolled back data: %s", err)
+	}
+	if err := db.ForgetLoadedFiles(tx, schema, table, bad.RunID); err != nil {
+		return err
+	}
+	// without a reload, the next stream load starts over from where the undone one started
+	if bad.Granularity == "stream" && reloadConf == nil {
+		watermark, err := db.Watermark(schema, table)
+		if err != nil {
+			return err
+		}
+		if watermark != nil && watermark.Equal(*bad.WindowEnd) {
+			if err := db.SetWatermark(tx, schema, table, *bad.WindowStart, runID); err != nil {
+				return err
+			}
+		}
+	}
+	if reloadConf != nil {
+		if err := db.Copy(tx, *reloadConf, reloadTable.Meta.Copy, flags.Delimiter, true, flags.GZip); err != nil {
+			return fmt.Errorf("err running copy: %w", err)
+		}
+		if rec.RowsLoaded, err = db.LastCopyCount(tx); err != nil {
+			return err
+		}
+	}
+	rec.Duration = time.Since(started)
+	if err := db.RecordLoad(tx, rec); err != nil {
+		return err
+	}
+	if err := db.UpdateLatencyInfo(tx, redshift.Table{Name: table, Meta: redshift.Meta{Schema: schema}}); err != nil {
+		return fmt.Errorf("err updating latency info: %s", err)
 	}
 
-	var duration time.Duration
-	if granularity == "day" {
-		duration = time.Hour * 24
-	} else {
-		duration = time.Hour
+	if flags.DryRun {
+		return tx.Rollback()
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("err committing transaction: %s", err)
+	}
+	postVacuumJob(schema, table)
+	return nil
+}
+
+// startEndFromGranularity returns the granularity period t falls in, as wall clock times in loc
+// like those in the data date column (see wallClock). Working on the wall clock keeps every day
+// midnight to midnight across DST changes, and an hour repeated when the clocks go back is the
+// same hour of the column.
+func startEndFromGranularity(t time.Time, granularity string, loc *time.Location) (time.Time, time.Time) {
+	start := truncateDate(wallClock(t, loc), granularity)
+	return start, nextPeriod(start, granularity)
+}
+
+// loadMode picks how to load a table: the payload's mode wins over the table config's,
+// and tables are appended to if neither sets one
+func loadMode(payloadMode string, table redshift.Table, truncate bool) (string, error) {
+	mode := payloadMode
+	if mode == "" {
+		mode = table.Meta.Mode
+	}
+	if mode == "" {
+		mode = redshift.ModeAppend
+	}
+	if mode != redshift.ModeAppend && mode != redshift.ModeMerge {
+		return "", fmt.Errorf("unsupported mode %q, must be one of %s or %s", mode, redshift.ModeAppend, redshift.ModeMerge)
+	}
+	if mode == redshift.ModeMerge && truncate {
+		return "", fmt.Errorf("truncate can't be used with mode %s", redshift.ModeMerge)
+	}
+	return mode, nil
+}
+
+// stopContext returns a context that's cancelled when the worker is asked to stop
+func stopContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, os.Signal(syscall.SIGTERM))
+	go func() {
+		for range c {
+			// sfncli will send signals to our container
+			// we should gracefully terminate any running SQL queries
+			cancel()
+		}
+	}()
+	return ctx
+}
+
+// connectRedshift connects to the cluster in the environment, cancelling running
+// queries once ctx is done
+func connectRedshift(ctx context.Context) *redshift.Redshift {
+	timeout := 60 // can parameterize later if this is an issue
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "5439"
+	}
+	db, err := redshift.NewRedshift(ctx, host, port, dbName, user, pwd, timeout)
+	fatalIfErr(err, "error getting redshift instance")
+	return db
+}
+
+// inferConfig finds each of the payload's tables' input data like a load would, and returns draft
+// configs for them guessed from their first rows
+func inferConfig(flags payload) ([]byte, error) {
+	if flags.InputBucket == "" || flags.DataDate == "" {
+		return nil, fmt.Errorf("bucket and date are needed to find the input data")
+	}
+	sampleRows, err := strconv.Atoi(flags.SampleRows)
+	if err != nil || sampleRows <= 0 {
+		return nil, fmt.Errorf("sampleRows must be a positive number, got %q", flags.SampleRows)
+	}
+	bucket, storage, err := openBucket(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	pathChecker := s3filepath.NewListingPathChecker(storage)
+	var tables []redshift.Table
+	for _, t := range strings.Split(flags.InputTables, ",") {
+		date, err := dataDate(flags.DataDate, storage, bucket, flags.InputSchemaName, t)
+		if err != nil {
+			return nil, fmt.Errorf("issue parsing date: %s", err)
+		}
+		inputConf, err := s3filepath.CreateS3File(pathChecker, bucket, flags.InputSchemaName, t, "", date)
+		if err != nil {
+			return nil, err
+		}
+		if inputConf.IsColumnar() {
+			return nil, fmt.Errorf("can't infer a config from %s files for %s", inputConf.Format, t)
+		}
+		files := []string{inputConf.GetDataFilename()}
+		if inputConf.Suffix == "manifest" {
+			manifest, err := s3filepath.InputManifest(storage, *inputConf)
+			if err != nil {
+				return nil, err
+			}
+			files = nil
+			for _, entry := range manifest.Entries {
+				files = append(files, entry.URL)
+			}
+		}
+
+		inferrer := redshift.NewTableInferrer(flags.Delimiter)
+		for _, file := range files {
+			if inferrer.Rows() >= sampleRows {
+				break
+			}
+			log.Printf("sampling %s", file)
+			reader, err := storage.Reader(file)
+			if err != nil {
+				return nil, fmt.Errorf("error opening %s: %s", file, err)
+			}
+			err = inferrer.Sample(reader, sampleRows-inferrer.Rows())
+			reader.Close()
+			if err != nil {
+				return nil, fmt.Errorf("error sampling %s: %s", file, err)
+			}
+		}
+		log.Printf("inferred %s.%s from %d rows", flags.InputSchemaName, t, inferrer.Rows())
+		tables = append(tables, inferrer.Table(flags.InputSchemaName, t))
+	}
+	return redshift.MarshalConfig(tables...)
+}
+
+// findInput finds the date and input file of the table, and its marker file if it needs one,
+// returning a s3filepath.NotFoundError if they aren't there yet. Each call lists the
+// bucket afresh, so it can be polled.
+func findInput(flags payload, storage s3filepath.Storage, bucket s3filepath.S3Bucket, table string) (time.Time, *s3filepath.S3File, error) {
+	date, err := dataDate(flags.DataDate, storage, bucket, flags.InputSchemaName, table)
+	if err != nil {
+		return date, nil, fmt.Errorf("issue parsing date %s: %w", flags.DataDate, err)
+	}
+	// one LIST finds whichever kind of input file the table has
+	pathChecker := s3filepath.NewListingPathChecker(storage)
+	inputConf, err := s3filepath.CreateS3File(pathChecker, bucket, flags.InputSchemaName, table, flags.ConfigFile, date)
+	return date, inputConf, err
+}
+
+// newLoadRecord starts the ledger record of loading the input file, with what's known before the load:
+// the input's size, the versions of its files and the hash of its config
+func newLoadRecord(storage s3filepath.Storage, runID string, inputConf s3filepath.S3File, flags payload) (redshift.LoadRecord, error) {
+	rec := redshift.LoadRecord{
+		RunID:       runID,
+		Operation:   redshift.OperationLoad,
+		Schema:      inputConf.Schema,
+		Table:       inputConf.Table,
+		Source:      inputConf.GetDataFilename(),
+		DataDate:    inputConf.DataDate,
+		Granularity: flags.TimeGranularity,
+		Forced:      flags.Force,
+	}
+	var err error
+	if rec.Bytes, err = s3filepath.InputSize(storage, inputConf); err != nil {
+		return rec, fmt.Errorf("error getting the size of %s: %s", rec.Source, err)
+	}
+	if rec.Files, err = s3filepath.InputVersions(storage, inputConf); err != nil {
+		return rec, fmt.Errorf("error getting the versions of %s: %s", rec.Source, err)
+	}
+	if rec.ConfigHash, err = fileHash(inputConf.ConfReader(), inputConf.ConfFile); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// finishedData is the data of the job-finished event of a load that didn't fail, whose status is
+// already-loaded if every table was skipped for having been loaded before
+func finishedData(alreadyLoaded []string, streamGaps []streamGap, tables []string) logger.M {
+	data := logger.M{}
+	if len(streamGaps) > 0 {
+		data["stream_gaps"] = streamGaps
+	}
+	if len(alreadyLoaded) > 0 {
+		data["already_loaded"] = alreadyLoaded
+		if len(alreadyLoaded) == len(tables) {
+			data["status"] = logger.StatusAlreadyLoaded
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+// streamTimeFormat is the format of the payload's streamStart and streamEnd, which are in UTC
+const streamTimeFormat = "2006-01-02T15:04:05"
+
+// streamWindow is the time range a load with stream granularity replaces
+type streamWindow struct {
+	Start, End time.Time
+}
+
+// streamGap is a time range between where a table's last stream load ended and where its next one
+// starts, which was either skipped over (a gap) or is loaded again (an overlap)
+type streamGap struct {
+	Schema string    `json:"schema"`
+	Table  string    `json:"table"`
+	Kind   string    `json:"kind"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// kinds of streamGap
+const (
+	streamGapKind     = "gap"
+	streamOverlapKind = "overlap"
+)
+
+// tableStreamWindow looks up the watermark of the input's table to work out its stream window
+func tableStreamWindow(db *redshift.Redshift, flags payload, inputConf s3filepath.S3File) (*streamWindow, *streamGap, error) {
+	watermark, err := db.Watermark(inputConf.Schema, inputConf.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newStreamWindow(flags, inputConf, watermark)
+}
+
+// newStreamWindow works out the time range of a stream load of the input: it starts at streamStart if
+// it's set, or else at the table's watermark, where its last stream load ended. It ends at streamEnd
+// if it's set, or else at the input's data date. A window that doesn't start at the watermark is
+// returned with the gap or overlap between them.
+func newStreamWindow(flags payload, inputConf s3filepath.S3File, watermark *time.Time) (*streamWindow, *streamGap, error) {
+	window := streamWindow{End: inputConf.DataDate.UTC()}
+	var err error
+	switch {
+	case flags.StreamStart != "":
+		if window.Start, err = time.Parse(streamTimeFormat, flags.StreamStart); err != nil {
+			return nil, nil, err
+		}
+	case watermark != nil:
+		window.Start = watermark.UTC()
+	default:
+		return nil, nil, fmt.Errorf("%s.%s has no watermark to start its stream window from, so streamStart is needed",
+			inputConf.Schema, inputConf.Table)
+	}
+	if flags.StreamEnd != "" {
+		if window.End, err = time.Parse(streamTimeFormat, flags.StreamEnd); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !window.End.After(window.Start) {
+		return nil, nil, fmt.Errorf("the stream window of %s.%s from %s to %s is empty",
+			inputConf.Schema, inputConf.Table, window.Start, window.End)
+	}
+
+	if watermark == nil || window.Start.Equal(*watermark) {
+		return &window, nil, nil
+	}
+	gap := streamGap{Schema: inputConf.Schema, Table: inputConf.Table, Kind: streamGapKind, From: watermark.UTC(), To: window.Start}
+	if window.Start.Before(*watermark) {
+		gap.Kind, gap.From, gap.To = streamOverlapKind, window.Start, watermark.UTC()
+	}
+	return &window, &gap, nil
+}
+
+// fileHash returns the hex sha256 of the file at path
+func fileHash(fr s3filepath.FileReader, path string) (string, error) {
+	reader, err := fr.Reader(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %s", path, err)
 	}
+	defer reader.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// newRunID returns an id for this run of the worker, which its loads are recorded under in the ledger
+func newRunID() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(random))
+}
+
+// stageJSONPaths writes the JSONPaths file for loading the table's columns from their sources to
+// the bucket's staging prefix, and sets the input file to be loaded with it. Tables whose columns
+// have no sources are loaded with JSON 'auto' as usual.
+func stageJSONPaths(w s3filepath.FileWriter, inputConf *s3filepath.S3File, inputTable redshift.Table, delimiter string) error {
+	jsonPaths, columns := inputTable.JSONPaths()
+	if jsonPaths == nil {
+		return nil
+	}
+	if delimiter != "" || inputConf.IsColumnar() {
+		return fmt.Errorf("column sources can only be used to load json, not %s", inputConf.GetDataFilename())
+	}
+	data, err := json.MarshalIndent(jsonPaths, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := inputConf.Bucket.StagedPath("jsonpaths", inputConf.Schema, inputConf.Table, inputConf.DataDate, "json")
+	if err := w.Write(path, data); err != nil {
+		return fmt.Errorf("error writing jsonpaths file %s: %s", path, err)
+	}
+	inputConf.JSONPaths = path
+	inputConf.Columns = columns
+	return nil
+}
 
-	start := t.UTC().Truncate(duration)
-	end := start.Add(duration)
-	return start, end
+// waitDurations parses the payload's waitFor and pollInterval, waitFor being 0 if it isn't set
+func waitDurations(flags payload) (time.Duration, time.Duration, error) {
+	var waitFor time.Duration
+	if flags.WaitFor != "" {
+		var err error
+		if waitFor, err = time.ParseDuration(flags.WaitFor); err != nil {
+			return 0, 0, err
+		}
+	}
+	pollInterval, err := time.ParseDuration(flags.PollInterval)
+	if err != nil {
+		return 0, 0, err
+	}
+	if waitFor < 0 || pollInterval <= 0 {
+		return 0, 0, fmt.Errorf("waitFor can't be negative and pollInterval must be positive")
+	}
+	return waitFor, pollInterval, nil
+}
+
+// dataDate parses the payload's date, which is either an RFC3339 timestamp, "latest" for the date of
+// the table's newest input file, or "latest-before:<RFC3339 timestamp>" for the newest one before then
+func dataDate(date string, lister s3filepath.Lister, bucket s3filepath.S3Bucket, schema, table string) (time.Time, error) {
+	switch {
+	case date == dateLatest:
+		return s3filepath.LatestDataDate(lister, bucket, schema, table, time.Time{})
+	case strings.HasPrefix(date, dateLatestBefore):
+		before, err := time.Parse(time.RFC3339, strings.TrimPrefix(date, dateLatestBefore))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return s3filepath.LatestDataDate(lister, bucket, schema, table, before)
+	}
+	return time.Parse(time.RFC3339, date)
+}
+
+// openBucket returns the payload's bucket, with its region looked up, and the storage it's in
+func openBucket(flags payload) (s3filepath.S3Bucket, s3filepath.Storage, error) {
+	bucket, err := newBucket(flags)
+	if err != nil {
+		return bucket, nil, err
+	}
+	storage := s3filepath.NewStorage(bucket)
+	if bucket.Region, err = storage.BucketRegion(bucket.Name); err != nil {
+		return bucket, nil, err
+	}
+	// listing s3 needs the region, which it would otherwise look up again
+	if pc, ok := storage.(s3filepath.S3PathChecker); ok {
+		pc.Region = bucket.Region
+		storage = pc
+	}
+	return bucket, storage, nil
+}
+
+// checkLoadable fails for buckets redshift can't COPY from, which local directories aren't,
+// unless it's a dry run that won't COPY anything
+func checkLoadable(bucket s3filepath.S3Bucket, dryRun bool) error {
+	if bucket.Endpoint == s3filepath.LocalEndpoint && !dryRun {
+		return fmt.Errorf("redshift can't load from the local directory %s, which can only be used with dryRun or infer-config", bucket.URL())
+	}
+	return nil
+}
+
+// newBucket returns the payload's bucket, with the layouts and markers of its files and the endpoint it's at.
+// A file:// bucket is a local directory.
+func newBucket(flags payload) (s3filepath.S3Bucket, error) {
+	bucket := s3filepath.S3Bucket{
+		Name:            flags.InputBucket,
+		RedshiftRoleARN: redshiftRoleARN,
+		Layout:          flags.Layout,
+		Endpoint:        flags.Endpoint,
+		Marker:          flags.RequireMarker,
+		PartPattern:     flags.PartPattern,
+		StagingPrefix:   flags.StagingPrefix,
+	}
+	if strings.HasPrefix(bucket.Name, s3filepath.LocalEndpoint) {
+		bucket.Name = strings.TrimPrefix(bucket.Name, s3filepath.LocalEndpoint)
+		bucket.Endpoint = s3filepath.LocalEndpoint
+	}
+	if flags.TableLayouts != "" {
+		if err := json.Unmarshal([]byte(flags.TableLayouts), &bucket.TableLayouts); err != nil {
+			return bucket, fmt.Errorf("tableLayouts must be a json object of table names to layouts: %s", err)
+		}
+	}
+	// waitForMarker is what requireMarker used to be called
+	if flags.WaitForMarker != "" {
+		if bucket.Marker != "" && bucket.Marker != flags.WaitForMarker {
+			return bucket, fmt.Errorf("waitForMarker and requireMarker are the same option, but are set differently")
+		}
+		bucket.Marker = flags.WaitForMarker
+	}
+	if flags.TableMarkers != "" {
+		if err := json.Unmarshal([]byte(flags.TableMarkers), &bucket.TableMarkers); err != nil {
+			return bucket, fmt.Errorf("tableMarkers must be a json object of table names to marker files: %s", err)
+		}
+	}
+	if _, err := path.Match(bucket.PartPattern, ""); err != nil {
+		return bucket, fmt.Errorf("bad partPattern %s: %s", bucket.PartPattern, err)
+	}
+	for _, t := range strings.Split(flags.InputTables, ",") {
+		if err := s3filepath.ValidateLayout(bucket.LayoutFor(t)); err != nil {
+			return bucket, err
+		}
+	}
+	return bucket, nil
+}
+
+// writeOutput writes data to path, which can be local or in s3, or to stdout if path is empty
+func writeOutput(path string, data []byte) error {
+	if path == "" {
+		_, err := fmt.Println(string(data))
+		return err
+	}
+	return pathio.Write(path, data)
 }
 
 type payload struct {
 	InputSchemaName string `config:"schema"`
 	InputTables     string `config:"tables"`
-	InputBucket     string `config:"bucket,required"`
+	InputBucket     string `config:"bucket"`
 	Truncate        bool   `config:"truncate"`
 	Force           bool   `config:"force"`
-	DataDate        string `config:"date,required"`
+	DataDate        string `config:"date"`
 	ConfigFile      string `config:"config"`
 	GZip            bool   `config:"gzip"`
 	Delimiter       string `config:"delimiter"`
@@ -291,8 +934,43 @@ type payload struct {
 	StreamEnd       string `config:"streamEnd"`
 	TargetTimezone  string `config:"timezone"`
 	SkipLoad        bool   `config:"skipLoad"`
+	Mode            string `config:"mode"`
+	DryRun          bool   `config:"dryRun"`
+	Command         string `config:"command"`
+	Output          string `config:"output"`
+	SampleRows      string `config:"sampleRows"`
+	Layout          string `config:"layout"`
+	TableLayouts    string `config:"tableLayouts"`
+	Endpoint        string `config:"endpoint"`
+	WaitFor         string `config:"waitFor"`
+	PollInterval    string `config:"pollInterval"`
+	WaitForMarker   string `config:"waitForMarker"`
+	RequireMarker   string `config:"requireMarker"`
+	TableMarkers    string `config:"tableMarkers"`
+	PartPattern     string `config:"partPattern"`
+	StagingPrefix   string `config:"stagingPrefix"`
+	RunID           string `config:"runId"`
+	Reload          bool   `config:"reload"`
 }
 
+// special values of the payload's date, see dataDate
+const (
+	dateLatest       = "latest"
+	dateLatestBefore = "latest-before:"
+)
+
+// commands the worker can run, picked with the payload's command
+const (
+	// commandLoad loads the input data into redshift, and is the default
+	commandLoad = "load"
+	// commandExportConfig writes the configs of existing tables
+	commandExportConfig = "export-config"
+	// commandInferConfig writes draft configs for new tables from samples of their input data
+	commandInferConfig = "infer-config"
+	// commandRollback undoes a past load of a table, see rollback
+	commandRollback = "rollback"
+)
+
 // This worker finds the latest file in s3 and uploads it to redshift
 // If the destination table does not exist, the worker creates it
 // If the destination table lacks columns, the worker creates those as well
@@ -323,13 +1001,82 @@ func main() {
 		StreamEnd:       "",
 		TargetTimezone:  "UTC",
 		SkipLoad:        false,
+		Mode:            "",
+		DryRun:          false,
+		Command:         commandLoad,
+		Output:          "",
+		SampleRows:      "1000",
+		Layout:          "",
+		TableLayouts:    "",
+		Endpoint:        "",
+		WaitFor:         "",
+		PollInterval:    "1m",
+		WaitForMarker:   "",
+		RequireMarker:   "",
+		TableMarkers:    "",
+		PartPattern:     "",
+		StagingPrefix:   "",
+		RunID:           "",
+		Reload:          false,
 	}
 
 	nextPayload, err := analyticspipeline.AnalyticsWorker(&flags)
 	if err != nil {
 		log.Fatalf("err: %#v", err)
 	}
-	defer analyticspipeline.PrintPayload(nextPayload)
+
+	switch flags.Command {
+	case commandLoad:
+	case commandExportConfig:
+		config, err := connectRedshift(stopContext()).ExportConfig(flags.InputSchemaName, strings.Split(flags.InputTables, ","))
+		if err != nil {
+			log.Fatalf("error exporting config: %s", err)
+		}
+		if err := writeOutput(flags.Output, config); err != nil {
+			log.Fatalf("error writing config: %s", err)
+		}
+		return
+	case commandInferConfig:
+		config, err := inferConfig(flags)
+		if err != nil {
+			log.Fatalf("error inferring config: %s", err)
+		}
+		if err := writeOutput(flags.Output, config); err != nil {
+			log.Fatalf("error writing config: %s", err)
+		}
+		return
+	case commandRollback:
+		db := connectRedshift(stopContext())
+		if flags.DryRun {
+			db.DryRun()
+		}
+		runID := newRunID()
+		log.Printf("run id: %s", runID)
+		staged := &dryRunWriter{}
+		if err := rollback(db, flags, runID, staged); err != nil {
+			log.Fatalf("error rolling back %s.%s: %s", flags.InputSchemaName, flags.InputTables, err)
+		}
+		if flags.DryRun {
+			vacuum := newVacuumJob(flags.InputSchemaName, flags.InputTables)
+			out, err := json.MarshalIndent([]tablePlan{{
+				Schema: flags.InputSchemaName, Table: flags.InputTables, Statements: db.Planned(), Staged: staged.paths, Vacuum: &vacuum,
+			}}, "", "  ")
+			if err != nil {
+				log.Fatalf("error encoding dry run plan: %s", err)
+			}
+			if err := writeOutput(flags.Output, out); err != nil {
+				log.Fatalf("error writing dry run plan: %s", err)
+			}
+		}
+		return
+	default:
+		log.Fatalf("unsupported command %q, must be one of %s, %s, %s or %s",
+			flags.Command, commandLoad, commandExportConfig, commandInferConfig, commandRollback)
+	}
+	// a dry run prints its plan instead, and shouldn't kick off anything downstream
+	if !flags.DryRun {
+		defer analyticspipeline.PrintPayload(nextPayload)
+	}
 
 	// If we're to skip the load, do it early. Don't print out the schema or job finished info.
 	// This wasn't a job that we did anything for.
@@ -339,95 +1086,206 @@ func main() {
 	}
 
 	payloadForSignalFx = fmt.Sprintf("--schema %s", flags.InputSchemaName)
-	defer logger.JobFinishedEvent(payloadForSignalFx, true)
+	// tables skipped because all of their input files were loaded before
+	var alreadyLoaded []string
+	var streamGaps []streamGap
+	defer func() {
+		logger.JobFinishedEventWithData(payloadForSignalFx, true,
+			finishedData(alreadyLoaded, streamGaps, strings.Split(flags.InputTables, ",")))
+	}()
 
 	if flags.DataDate == "" {
 		logger.JobFinishedEvent(payloadForSignalFx, false)
 		panic("No date provided")
 	}
+	if flags.InputBucket == "" {
+		logger.JobFinishedEvent(payloadForSignalFx, false)
+		panic("No bucket provided")
+	}
 
-	// verify that timeGranularity is a supported value. for convenience,
-	// we use the convention that granularities must be valid PostgreSQL dateparts
-	// (see: http://www.postgresql.org/docs/8.1/static/functions-datetime.html#FUNCTIONS-DATETIME-TRUNC)
-	supportedGranularities := map[string]bool{"hour": true, "day": true, "stream": true}
-	if !supportedGranularities[flags.TimeGranularity] {
+	// verify that timeGranularity is a supported value
+	if !granularities[flags.TimeGranularity] && flags.TimeGranularity != "stream" {
 		logger.JobFinishedEvent(payloadForSignalFx, false)
-		panic(fmt.Sprintf("Unsupported granularity, must be one of %v", getMapKeys(supportedGranularities)))
+		panic(fmt.Sprintf("Unsupported granularity, must be stream or one of %v", getMapKeys(granularities)))
 	}
 
 	// verify that targetTimezone is a supported Golang location (i.e. "America/Los_Angeles")
 	targetDataLocation, err := time.LoadLocation(flags.TargetTimezone)
 	fatalIfErr(err, fmt.Sprintf("unable to load timezone '%s'", flags.TargetTimezone))
 
-	awsRegion, locationErr := getRegionForBucket(flags.InputBucket)
-	fatalIfErr(locationErr, "error getting location for bucket "+flags.InputBucket)
+	waitFor, pollInterval, err := waitDurations(flags)
+	fatalIfErr(err, "invalid waitFor or pollInterval")
 
 	// use an custom bucket type for testablitity
-	bucket := s3filepath.S3Bucket{
-		Name:            flags.InputBucket,
-		Region:          awsRegion,
-		RedshiftRoleARN: redshiftRoleARN}
+	bucket, storage, err := openBucket(flags)
+	fatalIfErr(err, "error opening bucket "+flags.InputBucket)
+	fatalIfErr(checkLoadable(bucket, flags.DryRun), "error opening bucket "+flags.InputBucket)
 
-	timeout := 60 // can parameterize later if this is an issue
-	if host == "" {
-		host = "localhost"
+	// waiting for input data stops when the worker is asked to, as running queries do
+	ctx := stopContext()
+	db := connectRedshift(ctx)
+	if flags.DryRun {
+		db.DryRun()
 	}
-	if port == "" {
-		port = "5439"
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Signal(syscall.SIGTERM))
-	go func() {
-		for range c {
-			// sfncli will send signals to our container
-			// we should gracefully terminate any running SQL queries
-			cancel()
-		}
-	}()
-
-	db, err := redshift.NewRedshift(ctx, host, port, dbName, user, pwd, timeout)
-	fatalIfErr(err, "error getting redshift instance")
+	runID := newRunID()
+	log.Printf("run id: %s", runID)
 
 	var copyErrors error
+	// rows redshift rejected, so whoever is on call can fix the data without digging through the cluster
+	var loadErrors []redshift.LoadErrorRow
+	// problems with manifests, found before loading them
+	var manifestErrors []s3filepath.ManifestError
+	var plans []tablePlan
 	// for each table passed in - likely we could goroutine this out
 	for _, t := range strings.Split(flags.InputTables, ",") {
 		log.Printf("attempting to run on schema: %s table: %s", flags.InputSchemaName, t)
-		// override most recent data file
-		parsedInputDate, err := time.Parse(time.RFC3339, flags.DataDate)
-		fatalIfErr(err, fmt.Sprintf("issue parsing date: %s", flags.DataDate))
-		inputConf, err := s3filepath.CreateS3File(s3filepath.S3PathChecker{}, bucket, flags.InputSchemaName, t, flags.ConfigFile, parsedInputDate)
-		fatalIfErr(err, "Issue getting data file from s3")
+		// a dry run plans the rest of the tables when one can't be found or its config read,
+		// failing once the plan is written, as it does for COPY errors
+		failed := func(err error, msg string) bool {
+			if err == nil {
+				return false
+			}
+			if !flags.DryRun {
+				fatalIfErr(err, msg)
+			}
+			err = fmt.Errorf("%s: %s", msg, err)
+			log.Printf("error planning table %s: %s", t, err)
+			copyErrors = multierror.Append(copyErrors, err)
+			plans = append(plans, tablePlan{Schema: flags.InputSchemaName, Table: t, Error: err.Error()})
+			return true
+		}
+		var parsedInputDate time.Time
+		var inputConf *s3filepath.S3File
+		err := s3filepath.WaitFor(ctx, waitFor, pollInterval, func() error {
+			var err error
+			parsedInputDate, inputConf, err = findInput(flags, storage, bucket, t)
+			var notFound *s3filepath.NotFoundError
+			if errors.As(err, &notFound) && waitFor > 0 {
+				log.Printf("waiting for input data: %s", err)
+			}
+			return err
+		})
+		var notArrived *s3filepath.DataNotArrivedError
+		if errors.As(err, &notArrived) && !flags.DryRun {
+			logger.DataNotArrivedEvent(payloadForSignalFx, err)
+			log.Fatalf("giving up on table %s: %s", t, err)
+		}
+		if failed(err, "Issue getting data file from s3") {
+			continue
+		}
 		inputTable, err := db.GetTableFromConf(*inputConf) // allow passing explicit config later
-		fatalIfErr(err, "Issue getting table from input")
+		if failed(err, "Issue getting table from input") {
+			continue
+		}
+		mode, err := loadMode(flags.Mode, *inputTable, flags.Truncate)
+		if failed(err, "Issue choosing load mode") {
+			continue
+		}
 
 		// figure out what the current state of the table is to determine if the table is already up to date
 		targetTable, targetDataDate, err := db.GetTableMetadata(inputConf.Schema, inputConf.Table, inputTable.Meta.DataDateColumn)
-		if err != nil {
-			fatalIfErr(err, "Error getting existing latest table metadata") // use fatalIfErr to stay the same
+		if failed(err, "Error getting existing latest table metadata") {
+			continue
 		}
 
 		// unless --force, don't update unless input data is new
 		if flags.TimeGranularity != "stream" && isInputDataStale(parsedInputDate, targetDataDate, flags.TimeGranularity, targetDataLocation) {
 			if flags.Force == false {
 				log.Printf("Recent data already exists in db: %s", *targetDataDate)
+				plans = append(plans, tablePlan{Schema: inputConf.Schema, Table: t,
+					Skipped: fmt.Sprintf("recent data already exists in db: %s", *targetDataDate)})
 				continue
 			}
 			log.Printf("Forcing update of inputTable: %s", inputConf.Table)
 		}
 
-		if err := runCopy(
-			db, *inputConf, *inputTable, targetTable, flags.Truncate, flags.GZip, flags.Delimiter,
-			flags.TimeGranularity, flags.TargetTimezone, flags.StreamStart, flags.StreamEnd,
-		); err != nil {
-			log.Printf("error running copy for table %s: %s", t, err)
-			copyErrors = multierror.Append(copyErrors, err)
+		// catch copy options that don't apply to the input, and bad manifests, before redshift
+		// fails on them somewhere inside the transaction
+		copyErr := redshift.CheckCopyOptionsFor(inputTable.Meta.Copy, inputConf.Format, flags.Delimiter)
+		if copyErr == nil && inputConf.Suffix == "manifest" {
+			copyErr = s3filepath.ValidateInputManifest(storage, inputConf)
+		}
+		var manifestErr *s3filepath.ManifestError
+		if errors.As(copyErr, &manifestErr) {
+			manifestErrors = append(manifestErrors, *manifestErr)
+		}
+		var rec redshift.LoadRecord
+		if copyErr == nil {
+			rec, copyErr = newLoadRecord(storage, runID, *inputConf, flags)
+		}
+		// unless --force, don't load the same files twice, which a retried stream load would double-insert
+		if copyErr == nil && !flags.Force {
+			var loaded bool
+			if loaded, copyErr = db.AlreadyLoaded(inputConf.Schema, inputConf.Table, rec.Files); loaded {
+				log.Printf("Input files of %s.%s were already loaded, skipping", inputConf.Schema, t)
+				alreadyLoaded = append(alreadyLoaded, t)
+				plans = append(plans, tablePlan{Schema: inputConf.Schema, Table: t, Skipped: "input files already loaded"})
+				continue
+			}
+		}
+		var stream *streamWindow
+		if copyErr == nil && flags.TimeGranularity == "stream" {
+			var gap *streamGap
+			if stream, gap, copyErr = tableStreamWindow(db, flags, *inputConf); gap != nil {
+				log.Printf("%s of %s.%s from %s to %s since its last stream load", gap.Kind, inputConf.Schema, t, gap.From, gap.To)
+				streamGaps = append(streamGaps, *gap)
+			}
+		}
+		// manifests built for part files are only written once they're about to be loaded, and a dry
+		// run only lists what it would have written
+		var writer s3filepath.FileWriter = storage
+		staged := &dryRunWriter{}
+		if flags.DryRun {
+			writer = staged
+		}
+		if copyErr == nil {
+			copyErr = s3filepath.StageManifest(writer, inputConf)
+		}
+		if copyErr == nil {
+			copyErr = stageJSONPaths(writer, inputConf, *inputTable, flags.Delimiter)
+		}
+		if copyErr == nil {
+			copyErr = runCopy(
+				db, *inputConf, *inputTable, targetTable, flags.Truncate, flags.GZip, flags.Delimiter,
+				flags.TimeGranularity, targetDataLocation, stream, mode, flags.DryRun, rec,
+			)
+		}
+		if copyErr != nil {
+			log.Printf("error running copy for table %s: %s", t, copyErr)
+			copyErrors = multierror.Append(copyErrors, copyErr)
+			var loadErr *redshift.LoadError
+			if errors.As(copyErr, &loadErr) {
+				loadErrors = append(loadErrors, loadErr.Rows...)
+			}
 		} else {
 			// DON'T NEED TO CREATE VIEWS - will be handled by the refresh script
 			log.Printf("done with table: %s.%s", inputConf.Schema, t)
 		}
+		if flags.DryRun {
+			plan := tablePlan{Schema: inputConf.Schema, Table: t, Statements: db.Planned(), Staged: staged.paths}
+			if copyErr != nil {
+				plan.Error = copyErr.Error()
+			} else {
+				vacuum := newVacuumJob(inputConf.Schema, inputTable.Name)
+				plan.Vacuum = &vacuum
+			}
+			plans = append(plans, plan)
+		}
+	}
+	if flags.DryRun {
+		out, err := json.MarshalIndent(plans, "", "  ")
+		fatalIfErr(err, "error encoding dry run plan")
+		fatalIfErr(writeOutput(flags.Output, out), "error writing dry run plan")
 	}
 	if copyErrors != nil {
+		logger.JobFinishedEventWithData(payloadForSignalFx, false, logger.M{
+			"error":           copyErrors.Error(),
+			"run_id":          runID,
+			"already_loaded":  alreadyLoaded,
+			"load_errors":     loadErrors,
+			"manifest_errors": manifestErrors,
+			"stream_gaps":     streamGaps,
+		})
 		log.Fatalf("error loading tables: %s", copyErrors)
 	}
 }
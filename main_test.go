@@ -1,35 +1,90 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/Clever/s3-to-redshift/v3/logger"
+	redshift "github.com/Clever/s3-to-redshift/v3/redshift"
+	s3filepath "github.com/Clever/s3-to-redshift/v3/s3filepath"
 )
 
 func TestTimeGranularity(t *testing.T) {
+	locationPT, err := time.LoadLocation("America/Los_Angeles")
+	if !assert.NoError(t, err) {
+		return
+	}
 	baseTime := time.Date(2017, 7, 11, 12, 9, 0, 0, time.UTC)
 
-	start, end := startEndFromGranularity(baseTime, "day", "UTC")
+	start, end := startEndFromGranularity(baseTime, "day", time.UTC)
 	assert.Equal(t, start, time.Date(2017, 7, 11, 0, 0, 0, 0, time.UTC))
 	assert.Equal(t, end, time.Date(2017, 7, 12, 0, 0, 0, 0, time.UTC))
 
-	start, end = startEndFromGranularity(baseTime, "hour", "UTC")
+	start, end = startEndFromGranularity(baseTime, "hour", time.UTC)
 	assert.Equal(t, start, time.Date(2017, 7, 11, 12, 0, 0, 0, time.UTC))
 	assert.Equal(t, end, time.Date(2017, 7, 11, 13, 0, 0, 0, time.UTC))
 
 	// Simulate timestamps that cross timezones in PT vs UTC
 	baseTime = time.Date(2017, 7, 11, 4, 0, 0, 0, time.UTC)
 
-	start, end = startEndFromGranularity(baseTime, "day", "UTC")
+	start, end = startEndFromGranularity(baseTime, "day", time.UTC)
 	assert.Equal(t, start, time.Date(2017, 7, 11, 0, 0, 0, 0, time.UTC))
 	assert.Equal(t, end, time.Date(2017, 7, 12, 0, 0, 0, 0, time.UTC))
 
-	start, end = startEndFromGranularity(baseTime, "day", "America/Los_Angeles")
+	start, end = startEndFromGranularity(baseTime, "day", locationPT)
 	assert.Equal(t, start, time.Date(2017, 7, 10, 0, 0, 0, 0, time.UTC))
 	assert.Equal(t, end, time.Date(2017, 7, 11, 0, 0, 0, 0, time.UTC))
 }
 
+func TestTimeGranularityDST(t *testing.T) {
+	locationPT, err := time.LoadLocation("America/Los_Angeles")
+	if !assert.NoError(t, err) {
+		return
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	// in 2021 PT sprang forward at 2AM PST on March 14 (10AM UTC), and fell back at 2AM PDT on
+	// November 7 (9AM UTC). Windows are wall clock times in PT, like the data date column.
+	for _, tt := range []struct {
+		name        string
+		t           time.Time
+		granularity string
+		loc         *time.Location
+		start, end  time.Time
+	}{
+		{"minute", utc(2021, 3, 14, 9, 59), "minute", locationPT, utc(2021, 3, 14, 1, 59), utc(2021, 3, 14, 2, 0)},
+		{"15min", utc(2021, 3, 14, 10, 7), "15min", locationPT, utc(2021, 3, 14, 3, 0), utc(2021, 3, 14, 3, 15)},
+		{"15min in UTC", utc(2021, 3, 14, 10, 59), "15min", time.UTC, utc(2021, 3, 14, 10, 45), utc(2021, 3, 14, 11, 0)},
+		{"hour before spring forward", utc(2021, 3, 14, 9, 30), "hour", locationPT, utc(2021, 3, 14, 1, 0), utc(2021, 3, 14, 2, 0)},
+		{"hour after spring forward", utc(2021, 3, 14, 10, 30), "hour", locationPT, utc(2021, 3, 14, 3, 0), utc(2021, 3, 14, 4, 0)},
+		{"first 1AM hour of fall back", utc(2021, 11, 7, 8, 30), "hour", locationPT, utc(2021, 11, 7, 1, 0), utc(2021, 11, 7, 2, 0)},
+		{"second 1AM hour of fall back", utc(2021, 11, 7, 9, 30), "hour", locationPT, utc(2021, 11, 7, 1, 0), utc(2021, 11, 7, 2, 0)},
+		{"day of spring forward", utc(2021, 3, 14, 20, 0), "day", locationPT, utc(2021, 3, 14, 0, 0), utc(2021, 3, 15, 0, 0)},
+		{"day of fall back", utc(2021, 11, 8, 7, 59), "day", locationPT, utc(2021, 11, 7, 0, 0), utc(2021, 11, 8, 0, 0)},
+		{"day before spring forward", utc(2021, 3, 14, 7, 59), "day", locationPT, utc(2021, 3, 13, 0, 0), utc(2021, 3, 14, 0, 0)},
+		{"week across spring forward", utc(2021, 3, 10, 12, 0), "week", locationPT, utc(2021, 3, 8, 0, 0), utc(2021, 3, 15, 0, 0)},
+		{"week after fall back", utc(2021, 11, 8, 8, 0), "week", locationPT, utc(2021, 11, 8, 0, 0), utc(2021, 11, 15, 0, 0)},
+		{"sunday is the end of the week", utc(2021, 11, 8, 7, 59), "week", locationPT, utc(2021, 11, 1, 0, 0), utc(2021, 11, 8, 0, 0)},
+		{"month across spring forward", utc(2021, 3, 1, 8, 0), "month", locationPT, utc(2021, 3, 1, 0, 0), utc(2021, 4, 1, 0, 0)},
+		{"month in UTC", utc(2021, 3, 1, 7, 0), "month", time.UTC, utc(2021, 3, 1, 0, 0), utc(2021, 4, 1, 0, 0)},
+		{"local month before UTC's", utc(2021, 3, 1, 7, 0), "month", locationPT, utc(2021, 2, 1, 0, 0), utc(2021, 3, 1, 0, 0)},
+		{"quarter across fall back", utc(2021, 12, 31, 12, 0), "quarter", locationPT, utc(2021, 10, 1, 0, 0), utc(2022, 1, 1, 0, 0)},
+		{"quarter across the year", utc(2022, 1, 1, 7, 59), "quarter", locationPT, utc(2021, 10, 1, 0, 0), utc(2022, 1, 1, 0, 0)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := startEndFromGranularity(tt.t, tt.granularity, tt.loc)
+			assert.Equal(t, tt.start, start)
+			assert.Equal(t, tt.end, end)
+		})
+	}
+}
+
 func TestIsInputDataStale(t *testing.T) {
 	locationUTC, _ := time.LoadLocation("UTC")
 	inputDataDate, _ := time.Parse(time.RFC3339, "2017-08-15T14:00:00Z")
@@ -54,3 +109,309 @@ func TestIsInputDataStale(t *testing.T) {
 	assert.Equal(t, false, isInputDataStale(inputDataDateUTC, &targetDataDatePT, "day", locationUTC))
 	assert.Equal(t, true, isInputDataStale(inputDataDateUTC, &targetDataDatePT, "day", locationPT))
 }
+
+func TestLoadMode(t *testing.T) {
+	appendTable := redshift.Table{}
+	mergeTable := redshift.Table{Meta: redshift.Meta{Mode: redshift.ModeMerge}}
+
+	mode, err := loadMode("", appendTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, redshift.ModeAppend, mode)
+
+	mode, err = loadMode("", mergeTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, redshift.ModeMerge, mode)
+
+	// the payload overrides the config
+	mode, err = loadMode(redshift.ModeAppend, mergeTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, redshift.ModeAppend, mode)
+
+	_, err = loadMode("upsert", appendTable, false)
+	assert.Error(t, err)
+	_, err = loadMode(redshift.ModeMerge, appendTable, true)
+	assert.Error(t, err)
+}
+
+func TestNewVacuumJob(t *testing.T) {
+	job := newVacuumJob("api", "hits")
+	assert.Equal(t, cleanupWorker, job.Worker)
+	assert.Equal(t, `api."hits"`, job.Payload["targets"])
+	assert.Equal(t, "delete", job.Payload["vacuum_mode"])
+}
+
+func TestNewBucket(t *testing.T) {
+	bucket, err := newBucket(payload{
+		InputBucket:  "b",
+		InputTables:  "t,u",
+		Layout:       "{table}/{yyyy}.{suffix}",
+		TableLayouts: `{"u": "{table}/{hh}.{suffix}"}`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "{table}/{yyyy}.{suffix}", bucket.LayoutFor("t"))
+	assert.Equal(t, "{table}/{hh}.{suffix}", bucket.LayoutFor("u"))
+
+	bucket, err = newBucket(payload{InputBucket: "file:///tmp/b", InputTables: "t"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/b", bucket.Name)
+	assert.Equal(t, s3filepath.LocalEndpoint, bucket.Endpoint)
+	// redshift can't COPY from a local directory
+	assert.Error(t, checkLoadable(bucket, false))
+	assert.NoError(t, checkLoadable(bucket, true))
+	bucket, err = newBucket(payload{InputBucket: "b", InputTables: "t", Endpoint: "http://localhost:9000"})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", bucket.Name)
+	assert.NoError(t, checkLoadable(bucket, false))
+	assert.Equal(t, "http://localhost:9000", bucket.Endpoint)
+
+	bucket, err = newBucket(payload{InputBucket: "b", InputTables: "t,u", RequireMarker: "_SUCCESS", TableMarkers: `{"u": "{file}.done"}`})
+	assert.NoError(t, err)
+	assert.Equal(t, "_SUCCESS", bucket.MarkerFor("t"))
+	assert.Equal(t, "{file}.done", bucket.MarkerFor("u"))
+	_, err = newBucket(payload{InputTables: "t", TableMarkers: `"_SUCCESS"`})
+	assert.Error(t, err)
+
+	// waitForMarker is still understood
+	bucket, err = newBucket(payload{InputBucket: "b", InputTables: "t", WaitForMarker: "_SUCCESS"})
+	assert.NoError(t, err)
+	assert.Equal(t, "_SUCCESS", bucket.MarkerFor("t"))
+	_, err = newBucket(payload{InputTables: "t", WaitForMarker: "_SUCCESS", RequireMarker: "_DONE"})
+	assert.Error(t, err)
+
+	_, err = newBucket(payload{InputTables: "t", PartPattern: "part-[0-9"})
+	assert.Error(t, err)
+
+	_, err = newBucket(payload{InputTables: "t", TableLayouts: `["nope"]`})
+	assert.Error(t, err)
+	_, err = newBucket(payload{InputTables: "t", TableLayouts: `{"t": "{table}/{yyyy}.json"}`})
+	assert.Error(t, err)
+}
+
+type mockLister []string
+
+func (ml mockLister) ListFiles(prefix string) ([]string, error) {
+	return ml, nil
+}
+
+func TestDataDate(t *testing.T) {
+	bucket := s3filepath.S3Bucket{Name: "b", Layout: "{table}/{yyyy}-{mm}-{dd}.{suffix}"}
+	lister := mockLister{"s3://b/t/2017-08-14.json", "s3://b/t/2017-08-15.json"}
+
+	date, err := dataDate("2017-08-15T00:00:00Z", lister, bucket, "s", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2017, 8, 15, 0, 0, 0, 0, time.UTC), date)
+
+	date, err = dataDate("latest", lister, bucket, "s", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2017, 8, 15, 0, 0, 0, 0, time.UTC), date)
+
+	date, err = dataDate("latest-before:2017-08-15T00:00:00Z", lister, bucket, "s", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2017, 8, 14, 0, 0, 0, 0, time.UTC), date)
+
+	_, err = dataDate("latest-before:yesterday", lister, bucket, "s", "t")
+	assert.Error(t, err)
+	_, err = dataDate("", lister, bucket, "s", "t")
+	assert.Error(t, err)
+}
+
+func TestWaitDurations(t *testing.T) {
+	waitFor, pollInterval, err := waitDurations(payload{PollInterval: "1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), waitFor)
+	assert.Equal(t, time.Minute, pollInterval)
+
+	waitFor, pollInterval, err = waitDurations(payload{WaitFor: "2h", PollInterval: "30s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, waitFor)
+	assert.Equal(t, 30*time.Second, pollInterval)
+
+	for _, bad := range []payload{
+		{WaitFor: "soon", PollInterval: "1m"},
+		{WaitFor: "-1h", PollInterval: "1m"},
+		{WaitFor: "1h", PollInterval: "0s"},
+	} {
+		_, _, err := waitDurations(bad)
+		assert.Error(t, err)
+	}
+}
+
+type mockWriter map[string][]byte
+
+func (mw mockWriter) Write(path string, data []byte) error {
+	mw[path] = data
+	return nil
+}
+
+func TestStageJSONPaths(t *testing.T) {
+	date := time.Date(2017, 8, 15, 0, 0, 0, 0, time.UTC)
+	inputConf := s3filepath.S3File{Bucket: s3filepath.S3Bucket{Name: "b"}, Schema: "s", Table: "t", Suffix: "json", DataDate: date}
+	table := redshift.Table{Name: "t", Columns: []redshift.ColInfo{
+		{Name: "id", Type: "int"},
+		{Name: "user_id", Type: "int", Source: "$.user.id"},
+	}}
+
+	written := mockWriter{}
+	assert.NoError(t, stageJSONPaths(written, &inputConf, table, ""))
+	path := "s3://b/_staging/jsonpaths/s/t/s_t_2017-08-15T00:00:00Z.json"
+	assert.Equal(t, path, inputConf.JSONPaths)
+	assert.Equal(t, []string{"id", "user_id"}, inputConf.Columns)
+	assert.JSONEq(t, `{"jsonpaths": ["$['id']", "$.user.id"]}`, string(written[path]))
+
+	// a dry run only records what it would write, and still loads with it
+	staged := &dryRunWriter{}
+	inputConf.JSONPaths = ""
+	assert.NoError(t, stageJSONPaths(staged, &inputConf, table, ""))
+	assert.Equal(t, []string{path}, staged.paths)
+	assert.Equal(t, path, inputConf.JSONPaths)
+
+	// sources are only for json
+	inputConf = s3filepath.S3File{Bucket: s3filepath.S3Bucket{Name: "b"}, Schema: "s", Table: "t", Suffix: ".gz", DataDate: date}
+	assert.Error(t, stageJSONPaths(mockWriter{}, &inputConf, table, "|"))
+
+	// and without them there's nothing to stage
+	written = mockWriter{}
+	assert.NoError(t, stageJSONPaths(written, &inputConf, redshift.Table{Columns: table.Columns[:1]}, "|"))
+	assert.Empty(t, written)
+	assert.Equal(t, "", inputConf.JSONPaths)
+}
+
+func TestNewLoadRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3-to-redshift")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "t.json.gz"), []byte("data"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config_t.yml"), []byte("config"), 0644))
+
+	date := time.Date(2017, 8, 15, 0, 0, 0, 0, time.UTC)
+	bucket := s3filepath.S3Bucket{Name: dir, Endpoint: s3filepath.LocalEndpoint}
+	inputConf := s3filepath.S3File{
+		Bucket: bucket, Schema: "s", Table: "t", Suffix: "json.gz", DataDate: date,
+		Path: "file://" + dir + "/t.json.gz", ConfFile: "file://" + dir + "/config_t.yml",
+	}
+	rec, err := newLoadRecord(s3filepath.NewStorage(bucket), "run", inputConf, payload{TimeGranularity: "day", Force: true})
+	assert.NoError(t, err)
+	assert.Equal(t, redshift.LoadRecord{
+		RunID: "run", Operation: redshift.OperationLoad, Schema: "s", Table: "t", Source: inputConf.Path, DataDate: date, Granularity: "day",
+		Bytes: 4, Forced: true,
+		Files: []s3filepath.FileVersion{{URL: inputConf.Path, ETag: "8d777f385d3dfec8815d20f7496026dc"}},
+		// sha256 of "config"
+		ConfigHash: "b79606fb3afea5bd1609ed40b622142f1c98125abcfe89a76a661b0e8e343910",
+	}, rec)
+
+	inputConf.ConfFile = "file://" + dir + "/config_missing.yml"
+	_, err = newLoadRecord(s3filepath.NewStorage(bucket), "run", inputConf, payload{})
+	assert.Error(t, err)
+}
+
+func TestFinishedData(t *testing.T) {
+	tables := []string{"a", "b"}
+	assert.Nil(t, finishedData(nil, nil, tables))
+	assert.Equal(t, logger.M{"already_loaded": []string{"a"}}, finishedData([]string{"a"}, nil, tables))
+	assert.Equal(t, logger.M{"already_loaded": tables, "status": logger.StatusAlreadyLoaded}, finishedData(tables, nil, tables))
+	gaps := []streamGap{{Schema: "s", Table: "a", Kind: streamGapKind}}
+	assert.Equal(t, logger.M{"stream_gaps": gaps}, finishedData(nil, gaps, tables))
+}
+
+func TestNewStreamWindow(t *testing.T) {
+	date := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	watermark := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	inputConf := s3filepath.S3File{Schema: "s", Table: "t", DataDate: date}
+
+	for _, test := range []struct {
+		desc       string
+		flags      payload
+		watermark  *time.Time
+		start, end time.Time
+		gap        *streamGap
+	}{
+		{
+			desc:      "starts at the watermark and ends at the data date",
+			watermark: &watermark,
+			start:     watermark,
+			end:       date,
+		},
+		{
+			desc:      "explicit bounds override them",
+			flags:     payload{StreamStart: "2020-01-02T10:00:00", StreamEnd: "2020-01-02T11:00:00"},
+			watermark: &watermark,
+			start:     watermark,
+			end:       watermark.Add(time.Hour),
+		},
+		{
+			desc:  "tables without a watermark need a start",
+			flags: payload{StreamStart: "2020-01-02T09:00:00"},
+			start: watermark.Add(-time.Hour),
+			end:   date,
+		},
+		{
+			desc:      "starting after the watermark skips over a gap",
+			flags:     payload{StreamStart: "2020-01-02T11:00:00"},
+			watermark: &watermark,
+			start:     watermark.Add(time.Hour),
+			end:       date,
+			gap:       &streamGap{Schema: "s", Table: "t", Kind: streamGapKind, From: watermark, To: watermark.Add(time.Hour)},
+		},
+		{
+			desc:      "starting before it loads an overlap again",
+			flags:     payload{StreamStart: "2020-01-02T08:00:00"},
+			watermark: &watermark,
+			start:     watermark.Add(-2 * time.Hour),
+			end:       date,
+			gap:       &streamGap{Schema: "s", Table: "t", Kind: streamOverlapKind, From: watermark.Add(-2 * time.Hour), To: watermark},
+		},
+	} {
+		window, gap, err := newStreamWindow(test.flags, inputConf, test.watermark)
+		if assert.NoError(t, err, test.desc) {
+			assert.Equal(t, streamWindow{Start: test.start, End: test.end}, *window, test.desc)
+			assert.Equal(t, test.gap, gap, test.desc)
+		}
+	}
+
+	for _, flags := range []payload{
+		{},
+		{StreamStart: "yesterday"},
+		{StreamStart: "2020-01-02T09:00:00", StreamEnd: "2020-01-02T09:00:00"},
+	} {
+		_, _, err := newStreamWindow(flags, inputConf, nil)
+		assert.Error(t, err, "%+v", flags)
+	}
+}
+
+func TestRollbackArgs(t *testing.T) {
+	// these are checked before looking anything up
+	for _, flags := range []payload{
+		{InputTables: "", RunID: "run"},
+		{InputTables: "a,b", RunID: "run"},
+		{InputTables: "a"},
+		{InputTables: "a", RunID: "run", DataDate: "2020-01-02T00:00:00Z"},
+		{InputTables: "a", DataDate: "latest"},
+	} {
+		assert.Error(t, rollback(nil, flags, "run", &dryRunWriter{}), "%+v", flags)
+	}
+}
+
+func TestCheckRollback(t *testing.T) {
+	start := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	bad := redshift.LoadRecord{RunID: "bad", Schema: "s", Table: "t", WindowStart: &start, WindowEnd: &end}
+	assert.NoError(t, checkRollback(bad, nil, false))
+
+	// there's no window to delete
+	assert.Error(t, checkRollback(redshift.LoadRecord{RunID: "merged", Schema: "s", Table: "t"}, nil, false))
+
+	// truncating loads record their window, but deleted the rest of the table too
+	truncated := bad
+	truncated.Truncated = true
+	assert.Error(t, checkRollback(truncated, nil, true))
+
+	// deleting the window would take a later load's rows with it, unless forced
+	later := &redshift.LoadRecord{RunID: "fixed", Schema: "s", Table: "t", WindowStart: &start, WindowEnd: &end}
+	err := checkRollback(bad, later, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "fixed")
+	}
+	assert.NoError(t, checkRollback(bad, later, true))
+}
@@ -0,0 +1,104 @@
+package redshift
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// dataDateColumnNames are column names that usually hold the data date, most likely first
+var dataDateColumnNames = []string{"_data_timestamp", "time", "timestamp", "date", "created_at", "updated_at"}
+
+// ExportConfig reads existing tables and returns them in the config file format GetTableFromConf
+// reads, so that tables created by hand can be loaded by s3-to-redshift without writing their configs.
+func (r *Redshift) ExportConfig(schema string, tableNames []string) ([]byte, error) {
+	var tables []Table
+	for _, name := range tableNames {
+		table, err := r.getTable(schema, name)
+		if err != nil {
+			return nil, err
+		}
+		if table == nil {
+			return nil, fmt.Errorf("table %s.%s does not exist", schema, name)
+		}
+		tables = append(tables, configTable(*table))
+	}
+	return MarshalConfig(tables...)
+}
+
+// MarshalConfig writes tables in the config file format GetTableFromConf reads
+func MarshalConfig(tables ...Table) ([]byte, error) {
+	config := map[string]Table{}
+	for _, table := range tables {
+		if table.Meta.DataDateColumn == "" {
+			log.Printf("no data date column for %s.%s, it needs to be set in the config", table.Meta.Schema, table.Name)
+		}
+		config[table.Name] = table
+	}
+	return yaml.Marshal(config)
+}
+
+// configTable turns a table read from redshift into the table a config would describe:
+// types use the typeMapping shorthands where there is one, the data date column is guessed,
+// and the sort and dist styles are only kept if they aren't implied by the columns.
+func configTable(table Table) Table {
+	shorthands := map[string]string{}
+	for short, full := range typeMapping {
+		shorthands[full] = short
+	}
+	cols := make([]ColInfo, len(table.Columns))
+	for i, c := range table.Columns {
+		if short, ok := shorthands[c.Type]; ok {
+			c.Type = short
+		}
+		cols[i] = c
+	}
+	table.Columns = cols
+
+	table.Meta.DataDateColumn = guessDataDateColumn(table)
+	if table.Meta.SortStyle == SortCompound {
+		table.Meta.SortStyle = ""
+	}
+	if table.Meta.DistStyle == DistKey {
+		table.Meta.DistStyle = ""
+	}
+	return table
+}
+
+// guessDataDateColumn picks the timestamp or date column most likely to be the table's data date:
+// the first one in the sort key, else one with a usual name, else the first one.
+func guessDataDateColumn(table Table) string {
+	var candidates []ColInfo
+	for _, c := range table.Columns {
+		t, err := parseType(c.Type)
+		if err != nil {
+			continue
+		}
+		switch t.name {
+		case "timestamp without time zone", "timestamp with time zone", "date":
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		// columns outside of the sort key go last
+		oi, oj := candidates[i].SortOrdinal, candidates[j].SortOrdinal
+		return oi != 0 && (oj == 0 || oi < oj)
+	})
+	if candidates[0].SortOrdinal != 0 {
+		return candidates[0].Name
+	}
+	for _, name := range dataDateColumnNames {
+		for _, c := range candidates {
+			if c.Name == name {
+				return c.Name
+			}
+		}
+	}
+	return candidates[0].Name
+}
@@ -0,0 +1,82 @@
+package redshift
+
+import (
+	"fmt"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestExportConfig(t *testing.T) {
+	schema, table := "testschema", "testtable"
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	existRows := sqlmock.NewRows([]string{"table_name"}).AddRow(table)
+	mock.ExpectQuery(`SELECT table_name FROM information_schema.tables`).WillReturnRows(existRows)
+	colInfoRows := sqlmock.NewRows([]string{"name", "col_type", "default_val",
+		"not_null", "primary_key", "dist_key", "sort_ord", "encoding"})
+	colInfoRows.AddRow("id", "character varying(256)", "", true, true, true, 0, "lzo")
+	colInfoRows.AddRow("created_at", "timestamp without time zone", "", false, false, false, 0, "lzo")
+	colInfoRows.AddRow("time", "timestamp without time zone", "", false, false, false, 1, "lzo")
+	colInfoRows.AddRow("amount", "numeric(18,4)", "0", false, false, false, 0, "lzo")
+	mock.ExpectQuery(fmt.Sprintf(`SELECT .*nspname = '%s' .*relname = '%s'.*`, schema, table)).WillReturnRows(colInfoRows)
+	mock.ExpectQuery(`SELECT c.reldiststyle`).WillReturnRows(sqlmock.NewRows([]string{"reldiststyle"}).AddRow(1))
+	mock.ExpectQuery(`SELECT sortkey1 FROM svv_table_info`).WillReturnRows(sqlmock.NewRows([]string{"sortkey1"}).AddRow("time"))
+
+	config, err := mockRedshift.ExportConfig(schema, []string{table})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// it reads back as the config it would've been written as
+	var tables map[string]Table
+	assert.NoError(t, yaml.Unmarshal(config, &tables))
+	assert.Equal(t, map[string]Table{table: {
+		Name: table,
+		Columns: []ColInfo{
+			{"id", "text", "", true, true, true, 0, "", ""},
+			{"created_at", "timestamp", "", false, false, false, 0, "", ""},
+			{"time", "timestamp", "", false, false, false, 1, "", ""},
+			{"amount", "numeric(18,4)", "0", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: schema, DataDateColumn: "time"},
+	}}, tables)
+	_, err = tableAttributesSQL(tables[table])
+	assert.NoError(t, err)
+}
+
+func TestExportConfigMissingTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectQuery(`SELECT table_name FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"table_name"}))
+	_, err = mockRedshift.ExportConfig("testschema", []string{"nope"})
+	assert.Error(t, err)
+}
+
+func TestGuessDataDateColumn(t *testing.T) {
+	// sort key columns win
+	assert.Equal(t, "b", guessDataDateColumn(Table{Columns: []ColInfo{
+		{Name: "time", Type: "timestamp"},
+		{Name: "a", Type: "integer", SortOrdinal: 1},
+		{Name: "b", Type: "date", SortOrdinal: 2},
+	}}))
+	// then usual names
+	assert.Equal(t, "time", guessDataDateColumn(Table{Columns: []ColInfo{
+		{Name: "a", Type: "timestamptz"},
+		{Name: "time", Type: "timestamp"},
+	}}))
+	// then whatever comes first
+	assert.Equal(t, "a", guessDataDateColumn(Table{Columns: []ColInfo{
+		{Name: "a", Type: "timestamptz"},
+		{Name: "b", Type: "timestamp"},
+	}}))
+	assert.Equal(t, "", guessDataDateColumn(Table{Columns: []ColInfo{{Name: "a", Type: "integer"}}}))
+}
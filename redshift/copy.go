@@ -0,0 +1,187 @@
+package redshift
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// copyEncodings are the encodings COPY can load
+var copyEncodings = map[string]bool{"UTF8": true, "UTF16": true, "UTF16LE": true, "UTF16BE": true}
+
+// CopyOptions override or extend the options a table's input is COPYed with. By default CSV is
+// loaded with REMOVEQUOTES ESCAPE TRIMBLANKS EMPTYASNULL ACCEPTANYDATE, and CSV and JSON with
+// TIMEFORMAT 'auto' TRUNCATECOLUMNS; the pointer fields turn those off, or on for JSON.
+// See https://docs.aws.amazon.com/redshift/latest/dg/r_COPY.html
+type CopyOptions struct {
+	// CSV loads the input as CSV, with Quote as the quote character if set, rather than
+	// removing quotes and escapes
+	CSV          bool   `yaml:"csv,omitempty"`
+	Quote        string `yaml:"quote,omitempty"`
+	IgnoreHeader int    `yaml:"ignoreheader,omitempty"`
+	NullAs       string `yaml:"nullas,omitempty"`
+	DateFormat   string `yaml:"dateformat,omitempty"`
+	// TimeFormat replaces 'auto'
+	TimeFormat string `yaml:"timeformat,omitempty"`
+	MaxError   int    `yaml:"maxerror,omitempty"`
+	// AcceptInvChars is the character invalid UTF-8 characters are replaced with
+	AcceptInvChars string `yaml:"acceptinvchars,omitempty"`
+	BlanksAsNull   bool   `yaml:"blanksasnull,omitempty"`
+	Encoding       string `yaml:"encoding,omitempty"`
+
+	RemoveQuotes    *bool `yaml:"removequotes,omitempty"`
+	Escape          *bool `yaml:"escape,omitempty"`
+	TrimBlanks      *bool `yaml:"trimblanks,omitempty"`
+	EmptyAsNull     *bool `yaml:"emptyasnull,omitempty"`
+	AcceptAnyDate   *bool `yaml:"acceptanydate,omitempty"`
+	TruncateColumns *bool `yaml:"truncatecolumns,omitempty"`
+}
+
+// checkCopyOptions rejects options that are invalid or conflict with each other
+func checkCopyOptions(o CopyOptions) error {
+	switch {
+	case o.CSV && isSet(o.RemoveQuotes):
+		return fmt.Errorf("copy options csv and removequotes conflict")
+	case o.CSV && isSet(o.Escape):
+		return fmt.Errorf("copy options csv and escape conflict")
+	case o.Quote != "" && !o.CSV:
+		return fmt.Errorf("copy option quote requires csv")
+	case len(o.Quote) > 1:
+		return fmt.Errorf("copy option quote must be a single character, got %q", o.Quote)
+	case o.IgnoreHeader < 0:
+		return fmt.Errorf("copy option ignoreheader can't be negative")
+	case o.MaxError < 0 || o.MaxError > 100000:
+		return fmt.Errorf("copy option maxerror must be between 0 and 100000")
+	case o.Encoding != "" && !copyEncodings[strings.ToUpper(o.Encoding)]:
+		return fmt.Errorf("copy option encoding must be one of UTF8, UTF16, UTF16LE or UTF16BE, got %s", o.Encoding)
+	case len(o.AcceptInvChars) > 1 || (o.AcceptInvChars != "" && o.AcceptInvChars[0] > 127):
+		return fmt.Errorf("copy option acceptinvchars must be a single ASCII character, got %q", o.AcceptInvChars)
+	}
+	return nil
+}
+
+// CheckCopyOptionsFor rejects options that don't apply to the input format, which is only known
+// once the input file is found. It's meant to be called before the load's transaction starts.
+func CheckCopyOptionsFor(o CopyOptions, format, delimiter string) error {
+	if format != "" {
+		if !reflect.DeepEqual(o, CopyOptions{}) {
+			return fmt.Errorf("copy options can't be used for %s input", format)
+		}
+		return nil
+	}
+	if delimiter == "" {
+		for name, set := range map[string]bool{
+			"csv":          o.CSV,
+			"ignoreheader": o.IgnoreHeader != 0,
+			"nullas":       o.NullAs != "",
+			"removequotes": isSet(o.RemoveQuotes),
+			"escape":       isSet(o.Escape),
+		} {
+			if set {
+				return fmt.Errorf("copy option %s can't be used for json input", name)
+			}
+		}
+	}
+	return nil
+}
+
+// csvSQL returns the options for CSV input, which follow its DELIMITER
+func (o CopyOptions) csvSQL() string {
+	var opts []string
+	if o.CSV {
+		opts = append(opts, "CSV")
+		if o.Quote != "" {
+			opts = append(opts, fmt.Sprintf("QUOTE AS %s", quoteLiteral(o.Quote)))
+		}
+	}
+	// always removequotes, UNLOAD should add quotes
+	// always say escape for CSVs, UNLOAD should always escape
+	for _, opt := range []struct {
+		sql     string
+		setting *bool
+		def     bool
+	}{
+		{"REMOVEQUOTES", o.RemoveQuotes, !o.CSV},
+		{"ESCAPE", o.Escape, !o.CSV},
+		{"TRIMBLANKS", o.TrimBlanks, true},
+		{"EMPTYASNULL", o.EmptyAsNull, true},
+		{"ACCEPTANYDATE", o.AcceptAnyDate, true},
+	} {
+		if setting(opt.setting, opt.def) {
+			opts = append(opts, opt.sql)
+		}
+	}
+	if o.IgnoreHeader > 0 {
+		opts = append(opts, fmt.Sprintf("IGNOREHEADER %d", o.IgnoreHeader))
+	}
+	if o.NullAs != "" {
+		opts = append(opts, fmt.Sprintf("NULL AS %s", quoteLiteral(o.NullAs)))
+	}
+	return strings.Join(opts, " ")
+}
+
+// formatSQL returns the TIMEFORMAT and TRUNCATECOLUMNS options of CSV and JSON input
+func (o CopyOptions) formatSQL() string {
+	var opts []string
+	timeFormat := o.TimeFormat
+	if timeFormat == "" {
+		timeFormat = "auto"
+	}
+	opts = append(opts, fmt.Sprintf("TIMEFORMAT %s", quoteLiteral(timeFormat)))
+	if setting(o.TruncateColumns, true) {
+		opts = append(opts, "TRUNCATECOLUMNS")
+	}
+	return strings.Join(opts, " ")
+}
+
+// extraSQL returns the options that aren't set by default, which go last
+func (o CopyOptions) extraSQL(delimiter string) string {
+	var opts []string
+	if delimiter == "" {
+		// the CSV defaults only apply to JSON when asked for
+		for _, opt := range []struct {
+			sql     string
+			setting *bool
+		}{
+			{"TRIMBLANKS", o.TrimBlanks},
+			{"EMPTYASNULL", o.EmptyAsNull},
+			{"ACCEPTANYDATE", o.AcceptAnyDate},
+		} {
+			if setting(opt.setting, false) {
+				opts = append(opts, opt.sql)
+			}
+		}
+	}
+	if o.DateFormat != "" {
+		opts = append(opts, fmt.Sprintf("DATEFORMAT %s", quoteLiteral(o.DateFormat)))
+	}
+	if o.MaxError > 0 {
+		opts = append(opts, fmt.Sprintf("MAXERROR %d", o.MaxError))
+	}
+	if o.AcceptInvChars != "" {
+		opts = append(opts, fmt.Sprintf("ACCEPTINVCHARS AS %s", quoteLiteral(o.AcceptInvChars)))
+	}
+	if o.BlanksAsNull {
+		opts = append(opts, "BLANKSASNULL")
+	}
+	if o.Encoding != "" {
+		opts = append(opts, fmt.Sprintf("ENCODING %s", strings.ToUpper(o.Encoding)))
+	}
+	return strings.Join(opts, " ")
+}
+
+func isSet(b *bool) bool {
+	return b != nil && *b
+}
+
+func setting(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+// quoteLiteral quotes s as a SQL string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
@@ -0,0 +1,90 @@
+package redshift
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Clever/s3-to-redshift/v3/s3filepath"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCheckCopyOptions(t *testing.T) {
+	yes, no := true, false
+	for _, ok := range []CopyOptions{
+		{},
+		{CSV: true, Quote: "%", IgnoreHeader: 1, NullAs: `\N`},
+		{CSV: true, RemoveQuotes: &no, Escape: &no},
+		{DateFormat: "YYYY-MM-DD", TimeFormat: "epochsecs", MaxError: 10, AcceptInvChars: "?", BlanksAsNull: true, Encoding: "utf16"},
+		{TruncateColumns: &no, AcceptAnyDate: &yes},
+	} {
+		assert.NoError(t, checkCopyOptions(ok), "%+v", ok)
+	}
+	for _, bad := range []CopyOptions{
+		{CSV: true, RemoveQuotes: &yes},
+		{CSV: true, Escape: &yes},
+		{Quote: "'"},
+		{CSV: true, Quote: "ab"},
+		{IgnoreHeader: -1},
+		{MaxError: 100001},
+		{Encoding: "latin1"},
+		{AcceptInvChars: "??"},
+		{AcceptInvChars: "é"},
+	} {
+		assert.Error(t, checkCopyOptions(bad), "%+v", bad)
+	}
+}
+
+func TestCheckCopyOptionsFor(t *testing.T) {
+	assert.NoError(t, CheckCopyOptionsFor(CopyOptions{}, s3filepath.FormatParquet, ""))
+	assert.Error(t, CheckCopyOptionsFor(CopyOptions{MaxError: 1}, s3filepath.FormatParquet, ""))
+	assert.NoError(t, CheckCopyOptionsFor(CopyOptions{CSV: true, IgnoreHeader: 1}, "", ","))
+	assert.NoError(t, CheckCopyOptionsFor(CopyOptions{MaxError: 1, Encoding: "UTF8"}, "", ""))
+	for _, csvOnly := range []CopyOptions{{CSV: true}, {IgnoreHeader: 1}, {NullAs: "null"}} {
+		assert.Error(t, CheckCopyOptionsFor(csvOnly, "", ""), "%+v", csvOnly)
+	}
+}
+
+func TestRowCopySQLOptions(t *testing.T) {
+	f := s3filepath.S3File{Bucket: s3filepath.S3Bucket{Name: "b", Region: "r", Layout: "{table}.{suffix}"}, Table: "t", Suffix: "json"}
+	collapse := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	// the defaults
+	assert.Equal(t,
+		`COPY "s"."t" FROM 's3://b/t.json' WITH GZIP REGION 'r' TIMEFORMAT 'auto' TRUNCATECOLUMNS STATUPDATE ON `+
+			`DELIMITER AS '|' REMOVEQUOTES ESCAPE TRIMBLANKS EMPTYASNULL ACCEPTANYDATE`,
+		collapse(rowCopySQL(`"s"."t"`, f, CopyOptions{}, "|", "GZIP", "", "")))
+	assert.Equal(t,
+		`COPY "s"."t" FROM 's3://b/t.json' WITH JSON 'auto' REGION 'r' TIMEFORMAT 'auto' TRUNCATECOLUMNS STATUPDATE ON`,
+		collapse(rowCopySQL(`"s"."t"`, f, CopyOptions{}, "", "", "", "")))
+
+	// overridden and extended, as they'd be in a config
+	var opts CopyOptions
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+csv: true
+quote: "'"
+ignoreheader: 1
+nullas: \N
+trimblanks: false
+dateformat: YYYY-MM-DD
+maxerror: 10
+acceptinvchars: "?"
+blanksasnull: true
+encoding: utf16
+truncatecolumns: false
+`), &opts))
+	assert.NoError(t, checkCopyOptions(opts))
+	assert.Equal(t,
+		`COPY "s"."t" FROM 's3://b/t.json' WITH REGION 'r' TIMEFORMAT 'auto' STATUPDATE ON `+
+			`DELIMITER AS ',' CSV QUOTE AS '''' EMPTYASNULL ACCEPTANYDATE IGNOREHEADER 1 NULL AS '\N' `+
+			`DATEFORMAT 'YYYY-MM-DD' MAXERROR 10 ACCEPTINVCHARS AS '?' BLANKSASNULL ENCODING UTF16`,
+		collapse(rowCopySQL(`"s"."t"`, f, opts, ",", "", "", "")))
+
+	// json only gets the csv defaults when asked for
+	yes := true
+	assert.Equal(t,
+		`COPY "s"."t" FROM 's3://b/t.json' WITH JSON 'auto' REGION 'r' TIMEFORMAT 'epochmillisecs' TRUNCATECOLUMNS STATUPDATE ON ACCEPTANYDATE`,
+		collapse(rowCopySQL(`"s"."t"`, f, CopyOptions{TimeFormat: "epochmillisecs", AcceptAnyDate: &yes}, "", "", "", "")))
+}
@@ -0,0 +1,294 @@
+package redshift
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// inferredKind is what sampled values of a column look like, from narrowest to widest
+// within each family. Values of different families widen to kindString.
+type inferredKind int
+
+const (
+	kindNull inferredKind = iota
+	kindBoolean
+	kindSmallint
+	kindInteger
+	kindBigint
+	kindFloat
+	kindDate
+	kindTimestamp
+	kindSuper
+	kindString
+)
+
+// varcharStep is what inferred varchar lengths are rounded up to, to leave some room for longer values
+const varcharStep = 256
+
+// TableInferrer guesses a table config from sample rows of input data
+type TableInferrer struct {
+	delimiter string
+	rows      int
+	columns   []*inferredColumn
+	byName    map[string]*inferredColumn
+}
+
+type inferredColumn struct {
+	name   string
+	kind   inferredKind
+	maxLen int
+	// seen is the number of rows the column had a value in
+	seen int
+}
+
+// NewTableInferrer returns a TableInferrer for CSV data with the given delimiter,
+// or JSON data if the delimiter is empty, like Copy
+func NewTableInferrer(delimiter string) *TableInferrer {
+	return &TableInferrer{delimiter: delimiter, byName: map[string]*inferredColumn{}}
+}
+
+// Rows returns the number of rows sampled so far
+func (ti *TableInferrer) Rows() int {
+	return ti.rows
+}
+
+// Sample reads up to maxRows JSON objects or CSV rows from r, which may be gzipped.
+// CSV files don't name their columns, so CSV columns are named column_1, column_2, ...
+func (ti *TableInferrer) Sample(r io.Reader, maxRows int) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("error opening gzipped data: %s", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	if ti.delimiter == "" {
+		return ti.sampleJSON(r, maxRows)
+	}
+	return ti.sampleCSV(r, maxRows)
+}
+
+func (ti *TableInferrer) sampleJSON(r io.Reader, maxRows int) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for n := 0; n < maxRows; n++ {
+		// go through the object token by token to keep the order of its keys
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading json: %s", err)
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+			return fmt.Errorf("expected a json object, got %v", tok)
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return fmt.Errorf("error reading json: %s", err)
+			}
+			var value interface{}
+			if err := dec.Decode(&value); err != nil {
+				return fmt.Errorf("error reading json: %s", err)
+			}
+			// COPY matches json keys to columns case insensitively, and redshift lowercases column names
+			ti.add(strings.ToLower(key.(string)), value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("error reading json: %s", err)
+		}
+		ti.rows++
+	}
+	return nil
+}
+
+func (ti *TableInferrer) sampleCSV(r io.Reader, maxRows int) error {
+	cr := csv.NewReader(r)
+	cr.Comma, _ = utf8.DecodeRuneInString(ti.delimiter)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	for n := 0; n < maxRows; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading csv: %s", err)
+		}
+		for i, field := range record {
+			// COPY loads empty fields as nulls (EMPTYASNULL)
+			var value interface{}
+			if field != "" {
+				value = csvField(field)
+			}
+			ti.add(fmt.Sprintf("column_%d", i+1), value)
+		}
+		ti.rows++
+	}
+	return nil
+}
+
+func (ti *TableInferrer) add(name string, value interface{}) {
+	col, ok := ti.byName[name]
+	if !ok {
+		col = &inferredColumn{name: name}
+		ti.byName[name] = col
+		ti.columns = append(ti.columns, col)
+	}
+	if value == nil {
+		return
+	}
+	col.seen++
+	kind, length := inferKind(value)
+	col.kind = widenKind(col.kind, kind)
+	if length > col.maxLen {
+		col.maxLen = length
+	}
+}
+
+// inferKind returns the narrowest kind of value and the length it'd have as a string
+func inferKind(value interface{}) (inferredKind, int) {
+	switch v := value.(type) {
+	case bool:
+		return kindBoolean, len(strconv.FormatBool(v))
+	case json.Number:
+		return numberKind(v.String()), len(v.String())
+	case map[string]interface{}, []interface{}:
+		raw, _ := json.Marshal(v)
+		return kindSuper, len(raw)
+	case csvField:
+		// json has real booleans and numbers, but in CSV everything is a string
+		switch v {
+		case "true", "false", "t", "f":
+			return kindBoolean, len(v)
+		}
+		if kind := numberKind(string(v)); kind != kindString {
+			return kind, len(v)
+		}
+		return timeKind(string(v)), len(v)
+	case string:
+		return timeKind(v), len(v)
+	}
+	return kindString, len(fmt.Sprint(value))
+}
+
+// csvField is a field of a CSV row, as opposed to a json string
+type csvField string
+
+// timeKind returns whether s is a date or timestamp that COPY's TIMEFORMAT 'auto' understands
+func timeKind(s string) inferredKind {
+	if _, err := time.Parse("2006-01-02", s); err == nil {
+		return kindDate
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"} {
+		if _, err := time.Parse(layout, s); err == nil {
+			return kindTimestamp
+		}
+	}
+	return kindString
+}
+
+func numberKind(s string) inferredKind {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		switch {
+		case i >= -1<<15 && i < 1<<15:
+			return kindSmallint
+		case i >= -1<<31 && i < 1<<31:
+			return kindInteger
+		}
+		return kindBigint
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return kindFloat
+	}
+	return kindString
+}
+
+// widenKind returns the narrowest kind that holds values of both kinds
+func widenKind(a, b inferredKind) inferredKind {
+	if a > b {
+		a, b = b, a
+	}
+	switch {
+	case a == b || a == kindNull:
+		return b
+	case a >= kindSmallint && b <= kindFloat:
+		// integers widen to larger integers, and to floats
+		return b
+	case a == kindDate && b == kindTimestamp:
+		return b
+	}
+	return kindString
+}
+
+func (c inferredColumn) configType() string {
+	switch c.kind {
+	case kindBoolean:
+		return "boolean"
+	case kindSmallint:
+		return "smallint"
+	case kindInteger:
+		return "int"
+	case kindBigint:
+		return "bigint"
+	case kindFloat:
+		return "float"
+	case kindDate:
+		return "date"
+	case kindTimestamp:
+		return "timestamp"
+	case kindSuper:
+		return "super"
+	}
+	length := (c.maxLen + varcharStep - 1) / varcharStep * varcharStep
+	switch {
+	case length <= 256:
+		return "text"
+	case length >= 65535:
+		return "longtext"
+	}
+	return fmt.Sprintf("varchar(%d)", length)
+}
+
+// Table returns the draft config for the rows sampled so far. Columns that always had a value
+// are suggested to be not null, and the data date column is guessed and suggested as the sort key.
+// A column named id, or else the first one ending with _id, is suggested as the dist key, and
+// otherwise redshift is left to pick the dist style.
+func (ti *TableInferrer) Table(schema, name string) Table {
+	table := Table{Name: name, Meta: Meta{Schema: schema}}
+	for _, c := range ti.columns {
+		table.Columns = append(table.Columns, ColInfo{
+			Name:    c.name,
+			Type:    c.configType(),
+			NotNull: ti.rows > 0 && c.seen == ti.rows,
+		})
+	}
+
+	table.Meta.DataDateColumn = guessDataDateColumn(table)
+	distKey := -1
+	for i, c := range table.Columns {
+		if c.Name == table.Meta.DataDateColumn {
+			table.Columns[i].SortOrdinal = 1
+		}
+		if c.Name == "id" || (distKey < 0 && strings.HasSuffix(c.Name, "_id")) {
+			distKey = i
+		}
+	}
+	if distKey >= 0 {
+		table.Columns[distKey].DistKey = true
+	} else {
+		table.Meta.DistStyle = DistAuto
+	}
+	return table
+}
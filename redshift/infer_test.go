@@ -0,0 +1,88 @@
+package redshift
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferJSON(t *testing.T) {
+	data := `{"ID": "a1", "time": "2020-01-02T03:04:05Z", "count": 1, "big": 70000, "ratio": 1, "flag": true, "tags": ["x"], "day": "2020-01-02"}
+{"ID": "a2", "time": "2020-01-02T03:04:06Z", "count": 2, "big": 5000000000, "ratio": 0.5, "flag": false, "note": "hi", "day": "2020-01-02 10:00:00"}
+{"ID": "a3", "time": "2020-01-02T03:04:07Z", "count": null, "big": 1, "ratio": 2, "flag": "yes", "day": "2020-01-03"}
+`
+	ti := NewTableInferrer("")
+	assert.NoError(t, ti.Sample(strings.NewReader(data), 100))
+	assert.Equal(t, 3, ti.Rows())
+
+	assert.Equal(t, Table{
+		Name: "events",
+		Columns: []ColInfo{
+			{Name: "id", Type: "text", NotNull: true, DistKey: true},
+			{Name: "time", Type: "timestamp", NotNull: true, SortOrdinal: 1},
+			{Name: "count", Type: "smallint"},
+			{Name: "big", Type: "bigint", NotNull: true},
+			{Name: "ratio", Type: "float", NotNull: true},
+			// a boolean and a string
+			{Name: "flag", Type: "text", NotNull: true},
+			{Name: "tags", Type: "super"},
+			{Name: "day", Type: "timestamp", NotNull: true},
+			{Name: "note", Type: "text"},
+		},
+		Meta: Meta{Schema: "api", DataDateColumn: "time"},
+	}, ti.Table("api", "events"))
+}
+
+func TestInferCSV(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte("1|t|2020-01-02|" + strings.Repeat("x", 300) + "\n2||2020-01-03|y\n3|f|2020-01-04|\"z\"\n"))
+	gz.Close()
+
+	// gzipped input is detected, and rows are only read up to the limit
+	ti := NewTableInferrer("|")
+	assert.NoError(t, ti.Sample(&gzipped, 2))
+	assert.Equal(t, 2, ti.Rows())
+	table := ti.Table("api", "events")
+	assert.Equal(t, []ColInfo{
+		{Name: "column_1", Type: "smallint", NotNull: true},
+		{Name: "column_2", Type: "boolean"},
+		{Name: "column_3", Type: "date", NotNull: true, SortOrdinal: 1},
+		{Name: "column_4", Type: "varchar(512)", NotNull: true},
+	}, table.Columns)
+
+	// the suggested keys make a table we can create
+	_, err := tableAttributesSQL(table)
+	assert.NoError(t, err)
+}
+
+func TestInferDistKey(t *testing.T) {
+	ti := NewTableInferrer("")
+	assert.NoError(t, ti.Sample(strings.NewReader(`{"user_id": 1, "school_id": 2, "id": 3}`), 10))
+	table := ti.Table("api", "events")
+	assert.True(t, table.Columns[2].DistKey)
+	assert.False(t, table.Columns[0].DistKey)
+	assert.Equal(t, "", table.Meta.DistStyle)
+
+	ti = NewTableInferrer("")
+	assert.NoError(t, ti.Sample(strings.NewReader(`{"user_id": 1, "school_id": 2}`), 10))
+	assert.True(t, ti.Table("api", "events").Columns[0].DistKey)
+
+	// without any ids redshift picks
+	ti = NewTableInferrer("")
+	assert.NoError(t, ti.Sample(strings.NewReader(`{"name": "a"}`), 10))
+	assert.Equal(t, DistAuto, ti.Table("api", "events").Meta.DistStyle)
+}
+
+func TestWidenKind(t *testing.T) {
+	assert.Equal(t, kindBigint, widenKind(kindSmallint, kindBigint))
+	assert.Equal(t, kindFloat, widenKind(kindFloat, kindInteger))
+	assert.Equal(t, kindTimestamp, widenKind(kindDate, kindTimestamp))
+	assert.Equal(t, kindBoolean, widenKind(kindNull, kindBoolean))
+	assert.Equal(t, kindString, widenKind(kindBoolean, kindSmallint))
+	assert.Equal(t, kindString, widenKind(kindDate, kindInteger))
+	assert.Equal(t, kindString, widenKind(kindSuper, kindString))
+}
@@ -0,0 +1,50 @@
+package redshift
+
+import (
+	"fmt"
+	"strings"
+)
+
+// JSONPathsFile is a JSONPaths file, which maps the fields of JSON input to columns in order
+// See https://docs.aws.amazon.com/redshift/latest/dg/copy-parameters-data-format.html#copy-json-jsonpaths
+type JSONPathsFile struct {
+	JSONPaths []string `json:"jsonpaths"`
+}
+
+// JSONPaths returns the JSONPaths file for loading the table's columns from their sources, and
+// the columns it's for, in order. Columns without a source are loaded from the top level field
+// with their name. If none of the columns has a source, it returns nil, since JSON 'auto' will do.
+func (t Table) JSONPaths() (*JSONPathsFile, []string) {
+	hasSource := false
+	for _, c := range t.Columns {
+		hasSource = hasSource || c.Source != ""
+	}
+	if !hasSource {
+		return nil, nil
+	}
+	file := JSONPathsFile{}
+	var columns []string
+	for _, c := range t.Columns {
+		source := c.Source
+		if source == "" {
+			source = fmt.Sprintf("$['%s']", c.Name)
+		}
+		file.JSONPaths = append(file.JSONPaths, source)
+		columns = append(columns, c.Name)
+	}
+	return &file, columns
+}
+
+// checkSource checks that a column's source is a JSONPath expression COPY can load it with
+func checkSource(source string) error {
+	if source == "" {
+		return nil
+	}
+	if !strings.HasPrefix(source, "$") {
+		return fmt.Errorf("source %s must be a JSONPath expression starting with $", source)
+	}
+	if strings.ContainsAny(source, "*?@") || strings.Contains(source, "..") {
+		return fmt.Errorf("source %s must be a path to a single field, without wildcards, filters or ..", source)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package redshift
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPaths(t *testing.T) {
+	table := Table{Columns: []ColInfo{
+		{Name: "id", Type: "int"},
+		{Name: "user_id", Type: "text", Source: "$.user.id"},
+		{Name: "first_tag", Type: "text", Source: "$.tags[0]"},
+	}}
+	file, columns := table.JSONPaths()
+	assert.Equal(t, &JSONPathsFile{JSONPaths: []string{"$['id']", "$.user.id", "$.tags[0]"}}, file)
+	assert.Equal(t, []string{"id", "user_id", "first_tag"}, columns)
+
+	// without sources, JSON 'auto' will do
+	file, columns = Table{Columns: []ColInfo{{Name: "id", Type: "int"}}}.JSONPaths()
+	assert.Nil(t, file)
+	assert.Nil(t, columns)
+}
+
+func TestCheckSource(t *testing.T) {
+	for _, ok := range []string{"", "$.a", "$['a']['b']", "$.a[2].b"} {
+		assert.NoError(t, checkSource(ok), ok)
+	}
+	for _, bad := range []string{"a.b", "$.a[*]", "$..a", "$.a[?(@.b)]"} {
+		assert.Error(t, checkSource(bad), bad)
+	}
+}
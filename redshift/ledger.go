@@ -0,0 +1,321 @@
+package redshift
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Clever/pq"
+
+	"github.com/Clever/s3-to-redshift/v3/s3filepath"
+)
+
+// LedgerTable is the append-only table every load, and every rollback of one, is recorded in. It's
+// created by the first load that records to it.
+const LedgerTable = "load_ledger"
+
+// Operations recorded in the ledger
+const (
+	OperationLoad     = "load"
+	OperationRollback = "rollback"
+)
+
+const ledgerTableSQL = `CREATE TABLE IF NOT EXISTS ` + LedgerTable + ` (
+	run_id varchar(64) NOT NULL,
+	schema_name varchar(128) NOT NULL,
+	table_name varchar(128) NOT NULL,
+	source varchar(1024) NOT NULL,
+	data_date timestamp NOT NULL,
+	granularity varchar(16) NOT NULL,
+	data_date_column varchar(128) NOT NULL,
+	window_start timestamp,
+	window_end timestamp,
+	rows_deleted bigint NOT NULL,
+	rows_loaded bigint NOT NULL,
+	bytes bigint NOT NULL,
+	duration_ms bigint NOT NULL,
+	forced boolean NOT NULL,
+	truncated boolean NOT NULL,
+	config_hash varchar(64) NOT NULL,
+	operation varchar(16) NOT NULL,
+	rolled_back_run_id varchar(64) NOT NULL,
+	recorded_at timestamp NOT NULL DEFAULT getdate()
+)`
+
+// ledgerColumns are the columns of the ledger, in the order LoadRecords are read
+const ledgerColumns = `run_id, schema_name, table_name, source, data_date, granularity, data_date_column,
+	window_start, window_end, rows_deleted, rows_loaded, bytes, duration_ms, forced, truncated, config_hash,
+	operation, rolled_back_run_id, recorded_at`
+
+// LoadedFilesTable records the version of every file loaded into a table, so that files which were
+// loaded before can be skipped. Like the ledger, it's created by the first load that records to it.
+const LoadedFilesTable = "loaded_files"
+
+const loadedFilesTableSQL = `CREATE TABLE IF NOT EXISTS ` + LoadedFilesTable + ` (
+	run_id varchar(64) NOT NULL,
+	schema_name varchar(128) NOT NULL,
+	table_name varchar(128) NOT NULL,
+	url varchar(1024) NOT NULL,
+	etag varchar(64) NOT NULL,
+	version_id varchar(1024) NOT NULL,
+	recorded_at timestamp NOT NULL DEFAULT getdate()
+)`
+
+// undefinedTable is the error code of queries on tables that don't exist
+const undefinedTable = "42P01"
+
+// LoadRecord is a row of the ledger, recording what a load or rollback deleted and loaded
+type LoadRecord struct {
+	// RunID identifies the run of the worker that did the load, which may load several tables
+	RunID string
+	// Operation is OperationLoad or OperationRollback
+	Operation string
+	Schema    string
+	Table     string
+	// Source is the input file or manifest that was loaded, which is empty for rollbacks that
+	// didn't reload anything
+	Source         string
+	DataDate       time.Time
+	Granularity    string
+	DataDateColumn string
+	// WindowStart and WindowEnd are the time range deleted before loading, if one was
+	WindowStart *time.Time
+	WindowEnd   *time.Time
+	RowsDeleted int64
+	RowsLoaded  int64
+	// Bytes is the size of the input, as far as it's known
+	Bytes     int64
+	Duration  time.Duration
+	Forced    bool
+	Truncated bool
+	// ConfigHash is the hex sha256 of the table's config file
+	ConfigHash string
+	// RolledBack is the run whose load of the table a rollback undid
+	RolledBack string
+	// Files are the versions of the files loaded, which are recorded in LoadedFilesTable
+	Files []s3filepath.FileVersion
+	// RecordedAt is when the record was written, which is only set for records read from the ledger
+	RecordedAt time.Time
+}
+
+// RecordLoad appends the load to the ledger and its files to LoadedFilesTable, creating them if
+// they don't exist yet. It's meant to be run in the load's transaction, so the load and its record
+// commit together.
+func (r *Redshift) RecordLoad(tx *sql.Tx, rec LoadRecord) error {
+	insertSQL := fmt.Sprintf(`INSERT INTO %s (run_id, schema_name, table_name, source, data_date, granularity,
+			data_date_column, window_start, window_end, rows_deleted, rows_loaded, bytes, duration_ms, forced,
+			truncated, config_hash, operation, rolled_back_run_id)
+		VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %d, %d, %d, %d, %t, %t, %s, %s, %s)`,
+		LedgerTable, quoteLiteral(rec.RunID), quoteLiteral(rec.Schema), quoteLiteral(rec.Table),
+		quoteLiteral(rec.Source), timestampSQL(&rec.DataDate), quoteLiteral(rec.Granularity),
+		quoteLiteral(rec.DataDateColumn), timestampSQL(rec.WindowStart), timestampSQL(rec.WindowEnd),
+		rec.RowsDeleted, rec.RowsLoaded, rec.Bytes, rec.Duration.Milliseconds(), rec.Forced, rec.Truncated,
+		quoteLiteral(rec.ConfigHash), quoteLiteral(rec.Operation), quoteLiteral(rec.RolledBack))
+	queries := []string{ledgerTableSQL, insertSQL}
+	if len(rec.Files) > 0 {
+		var rows []string
+		for _, f := range rec.Files {
+			rows = append(rows, fmt.Sprintf("(%s, %s, %s, %s, %s, %s)", quoteLiteral(rec.RunID), quoteLiteral(rec.Schema),
+				quoteLiteral(rec.Table), quoteLiteral(f.URL), quoteLiteral(f.ETag), quoteLiteral(f.VersionID)))
+		}
+		queries = append(queries, loadedFilesTableSQL, fmt.Sprintf(
+			"INSERT INTO %s (run_id, schema_name, table_name, url, etag, version_id) VALUES %s",
+			LoadedFilesTable, strings.Join(rows, ", ")))
+	}
+	for _, q := range queries {
+		if r.skip(q, false) {
+			continue
+		}
+		if _, err := tx.ExecContext(r.ctx, q); err != nil {
+			return fmt.Errorf("error recording %s of %s.%s in %s: %s", rec.Operation, rec.Schema, rec.Table, LedgerTable, err)
+		}
+	}
+	return nil
+}
+
+// FindLoad returns the latest load of the table in the ledger that hasn't been rolled back, by the
+// run with runID if it's set, or else of the data date
+func (r *Redshift) FindLoad(schema, table, runID string, dataDate time.Time) (*LoadRecord, error) {
+	match := fmt.Sprintf("data_date = %s", timestampSQL(&dataDate))
+	if runID != "" {
+		match = fmt.Sprintf("run_id = %s", quoteLiteral(runID))
+	}
+	records, err := r.loadRecords(schema, table, match)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no load of %s.%s matching %s that hasn't been rolled back found in %s", schema, table, match, LedgerTable)
+	}
+	return &records[0], nil
+}
+
+// PreviousLoad returns the latest load of the same time window of the table before the given one
+// that hasn't been rolled back, or nil if there isn't one
+func (r *Redshift) PreviousLoad(rec LoadRecord) (*LoadRecord, error) {
+	if rec.WindowStart == nil || rec.WindowEnd == nil {
+		return nil, fmt.Errorf("the load of %s.%s by run %s has no time window", rec.Schema, rec.Table, rec.RunID)
+	}
+	records, err := r.loadRecords(rec.Schema, rec.Table, fmt.Sprintf(
+		"window_start = %s AND window_end = %s AND recorded_at < %s AND run_id != %s",
+		timestampSQL(rec.WindowStart), timestampSQL(rec.WindowEnd), timestampSQL(&rec.RecordedAt), quoteLiteral(rec.RunID)))
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// LaterLoad returns the latest load of the same time window of the table after the given one that
+// hasn't been rolled back, which replaced it, or nil if there isn't one
+func (r *Redshift) LaterLoad(rec LoadRecord) (*LoadRecord, error) {
+	if rec.WindowStart == nil || rec.WindowEnd == nil {
+		return nil, fmt.Errorf("the load of %s.%s by run %s has no time window", rec.Schema, rec.Table, rec.RunID)
+	}
+	records, err := r.loadRecords(rec.Schema, rec.Table, fmt.Sprintf(
+		"window_start = %s AND window_end = %s AND recorded_at > %s AND run_id != %s",
+		timestampSQL(rec.WindowStart), timestampSQL(rec.WindowEnd), timestampSQL(&rec.RecordedAt), quoteLiteral(rec.RunID)))
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// loadRecords returns the loads of the table in the ledger matching the condition that haven't been
+// rolled back, latest first
+func (r *Redshift) loadRecords(schema, table, condition string) ([]LoadRecord, error) {
+	query := fmt.Sprintf(`SELECT %s FROM %s
+		WHERE schema_name = %s AND table_name = %s AND operation = %s AND %s
+		AND run_id NOT IN (
+			SELECT rolled_back_run_id FROM %s
+			WHERE schema_name = %s AND table_name = %s AND operation = %s
+		)
+		ORDER BY recorded_at DESC`,
+		ledgerColumns, LedgerTable, quoteLiteral(schema), quoteLiteral(table), quoteLiteral(OperationLoad), condition,
+		LedgerTable, quoteLiteral(schema), quoteLiteral(table), quoteLiteral(OperationRollback))
+	rows, err := r.QueryContext(r.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up loads of %s.%s in %s: %s", schema, table, LedgerTable, err)
+	}
+	defer rows.Close()
+	var records []LoadRecord
+	for rows.Next() {
+		var rec LoadRecord
+		var windowStart, windowEnd pq.NullTime
+		var durationMS int64
+		if err := rows.Scan(&rec.RunID, &rec.Schema, &rec.Table, &rec.Source, &rec.DataDate, &rec.Granularity,
+			&rec.DataDateColumn, &windowStart, &windowEnd, &rec.RowsDeleted, &rec.RowsLoaded, &rec.Bytes, &durationMS,
+			&rec.Forced, &rec.Truncated, &rec.ConfigHash, &rec.Operation, &rec.RolledBack, &rec.RecordedAt); err != nil {
+			return nil, fmt.Errorf("error scanning loads of %s.%s: %s", schema, table, err)
+		}
+		if windowStart.Valid && windowEnd.Valid {
+			rec.WindowStart, rec.WindowEnd = &windowStart.Time, &windowEnd.Time
+		}
+		rec.Duration = time.Duration(durationMS) * time.Millisecond
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading loads of %s.%s: %s", schema, table, err)
+	}
+	return records, nil
+}
+
+// ForgetLoadedFiles removes the files the run loaded into the table from LoadedFilesTable, so that
+// they aren't skipped once the load is rolled back
+func (r *Redshift) ForgetLoadedFiles(tx *sql.Tx, schema, table, runID string) error {
+	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE schema_name = %s AND table_name = %s AND run_id = %s",
+		LoadedFilesTable, quoteLiteral(schema), quoteLiteral(table), quoteLiteral(runID))
+	// the table may not exist yet, which would abort the transaction
+	for _, q := range []string{loadedFilesTableSQL, deleteSQL} {
+		if r.skip(q, false) {
+			continue
+		}
+		if _, err := tx.ExecContext(r.ctx, q); err != nil {
+			return fmt.Errorf("error forgetting files loaded into %s.%s by run %s: %s", schema, table, runID, err)
+		}
+	}
+	return nil
+}
+
+// PartlyLoadedError is returned by AlreadyLoaded when only some of the files were loaded into the
+// table before, since loading them all again could insert the earlier ones' rows twice
+type PartlyLoadedError struct {
+	Target string
+	Loaded []string
+	Total  int
+}
+
+func (e *PartlyLoadedError) Error() string {
+	return fmt.Sprintf("%d of the %d input files of %s were already loaded, pass force to load them all again: %s",
+		len(e.Loaded), e.Total, e.Target, strings.Join(e.Loaded, ", "))
+}
+
+// AlreadyLoaded tells if every one of the files was loaded into the table before, with the same
+// version, and returns a *PartlyLoadedError if only some of them were. It runs outside of any
+// transaction, since it only reads.
+func (r *Redshift) AlreadyLoaded(schema, table string, files []s3filepath.FileVersion) (bool, error) {
+	if len(files) == 0 {
+		return false, nil
+	}
+	urls := make([]string, 0, len(files))
+	for _, f := range files {
+		urls = append(urls, quoteLiteral(f.URL))
+	}
+	query := fmt.Sprintf(`SELECT url, etag, version_id FROM %s
+		WHERE schema_name = %s AND table_name = %s AND url IN (%s)`,
+		LoadedFilesTable, quoteLiteral(schema), quoteLiteral(table), strings.Join(urls, ", "))
+	rows, err := r.QueryContext(r.ctx, query)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == undefinedTable {
+		// nothing has been recorded yet
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error looking up loaded files of %s.%s: %s", schema, table, err)
+	}
+	defer rows.Close()
+	loaded := map[s3filepath.FileVersion]bool{}
+	for rows.Next() {
+		var f s3filepath.FileVersion
+		if err := rows.Scan(&f.URL, &f.ETag, &f.VersionID); err != nil {
+			return false, fmt.Errorf("error scanning loaded files of %s.%s: %s", schema, table, err)
+		}
+		loaded[f] = true
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("error reading loaded files of %s.%s: %s", schema, table, err)
+	}
+	var alreadyLoaded []string
+	for _, f := range files {
+		if loaded[f] {
+			alreadyLoaded = append(alreadyLoaded, f.URL)
+		}
+	}
+	switch len(alreadyLoaded) {
+	case 0:
+		return false, nil
+	case len(files):
+		return true, nil
+	default:
+		return false, &PartlyLoadedError{Target: fmt.Sprintf("%s.%s", schema, table), Loaded: alreadyLoaded, Total: len(files)}
+	}
+}
+
+// LastCopyCount returns the number of rows loaded by the transaction's last COPY, which is 0
+// on a dry run since the COPY wasn't run
+func (r *Redshift) LastCopyCount(tx *sql.Tx) (int64, error) {
+	if r.dryRun {
+		return 0, nil
+	}
+	var count int64
+	if err := tx.QueryRowContext(r.ctx, "SELECT pg_last_copy_count()").Scan(&count); err != nil {
+		return 0, fmt.Errorf("error getting the number of rows loaded: %s", err)
+	}
+	return count, nil
+}
+
+// timestampSQL returns the timestamp literal for t, or NULL if there isn't one
+func timestampSQL(t *time.Time) string {
+	if t == nil {
+		return "NULL"
+	}
+	return quoteLiteral(t.UTC().Format("2006-01-02 15:04:05"))
+}
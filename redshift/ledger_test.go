@@ -0,0 +1,230 @@
+package redshift
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Clever/pq"
+	"github.com/Clever/s3-to-redshift/v3/s3filepath"
+)
+
+func TestRecordLoad(t *testing.T) {
+	start := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	rec := LoadRecord{
+		RunID:          "run",
+		Operation:      OperationLoad,
+		Schema:         "testschema",
+		Table:          "tablename",
+		Source:         "s3://bucket/it's.json.gz",
+		DataDate:       start,
+		Granularity:    "day",
+		DataDateColumn: "time",
+		WindowStart:    &start,
+		WindowEnd:      &end,
+		RowsDeleted:    3,
+		RowsLoaded:     5,
+		Bytes:          1024,
+		Duration:       1500 * time.Millisecond,
+		Forced:         true,
+		ConfigHash:     "abc",
+		Files: []s3filepath.FileVersion{
+			{URL: "s3://bucket/a.json.gz", ETag: "e1"},
+			{URL: "s3://bucket/b.json.gz", ETag: "e2", VersionID: "v"},
+		},
+	}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_last_copy_count\(\)`).WillReturnRows(sqlmock.NewRows([]string{"pg_last_copy_count"}).AddRow(5))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS load_ledger`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO load_ledger .* VALUES \('run', 'testschema', 'tablename', 's3://bucket/it''s.json.gz', ` +
+		`'2020-01-02 00:00:00', 'day', 'time', '2020-01-02 00:00:00', '2020-01-03 00:00:00', 3, 5, 1024, 1500, true, false, 'abc', ` +
+		`'load', ''\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS loaded_files`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO loaded_files \(run_id, schema_name, table_name, url, etag, version_id\) VALUES ` +
+		`\('run', 'testschema', 'tablename', 's3://bucket/a.json.gz', 'e1', ''\), ` +
+		`\('run', 'testschema', 'tablename', 's3://bucket/b.json.gz', 'e2', 'v'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	count, err := mockRedshift.LastCopyCount(tx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+	assert.NoError(t, mockRedshift.RecordLoad(tx, rec))
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// a dry run plans the record instead, and loads nothing
+	mockRedshift.DryRun()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	tx, err = mockRedshift.Begin()
+	assert.NoError(t, err)
+	count, err = mockRedshift.LastCopyCount(tx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	rec.WindowStart, rec.WindowEnd, rec.Truncated, rec.Files = nil, nil, true, nil
+	rec.Operation, rec.RolledBack = OperationRollback, "bad"
+	assert.NoError(t, mockRedshift.RecordLoad(tx, rec))
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	planned := mockRedshift.Planned()
+	if assert.Len(t, planned, 2) {
+		assert.Regexp(t, `^CREATE TABLE IF NOT EXISTS load_ledger`, planned[0].SQL)
+		assert.Regexp(t, `'day', 'time', NULL, NULL, 3, 5, 1024, 1500, true, true, 'abc', 'rollback', 'bad'\)$`, planned[1].SQL)
+	}
+}
+
+func TestAlreadyLoaded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+	files := []s3filepath.FileVersion{
+		{URL: "s3://bucket/a.json.gz", ETag: "e1"},
+		{URL: "s3://bucket/b.json.gz", ETag: "e2", VersionID: "v"},
+	}
+	query := `SELECT url, etag, version_id FROM loaded_files WHERE schema_name = 's' AND table_name = 't' ` +
+		`AND url IN \('s3://bucket/a.json.gz', 's3://bucket/b.json.gz'\)`
+	loadedRows := func() sqlmock.Rows {
+		return sqlmock.NewRows([]string{"url", "etag", "version_id"}).
+			AddRow("s3://bucket/a.json.gz", "e1", "").
+			AddRow("s3://bucket/b.json.gz", "e2", "v")
+	}
+
+	// before anything is recorded, there's no table
+	mock.ExpectQuery(query).WillReturnError(&pq.Error{Code: undefinedTable})
+	loaded, err := mockRedshift.AlreadyLoaded("s", "t", files)
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+
+	mock.ExpectQuery(query).WillReturnRows(loadedRows())
+	loaded, err = mockRedshift.AlreadyLoaded("s", "t", files)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+
+	// a new version of any of them would load the others again
+	mock.ExpectQuery(query).WillReturnRows(loadedRows())
+	files[1].ETag = "e3"
+	loaded, err = mockRedshift.AlreadyLoaded("s", "t", files)
+	assert.False(t, loaded)
+	var partlyLoaded *PartlyLoadedError
+	if assert.True(t, errors.As(err, &partlyLoaded)) {
+		assert.Equal(t, &PartlyLoadedError{Target: "s.t", Loaded: []string{"s3://bucket/a.json.gz"}, Total: 2}, partlyLoaded)
+	}
+
+	// none of them were loaded
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"url", "etag", "version_id"}))
+	loaded, err = mockRedshift.AlreadyLoaded("s", "t", files)
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+
+	mock.ExpectQuery(query).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = mockRedshift.AlreadyLoaded("s", "t", files)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	loaded, err = mockRedshift.AlreadyLoaded("s", "t", nil)
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+}
+
+func TestFindLoad(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	date := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	end := date.Add(24 * time.Hour)
+	recorded := date.Add(time.Hour)
+	columns := []string{"run_id", "schema_name", "table_name", "source", "data_date", "granularity", "data_date_column",
+		"window_start", "window_end", "rows_deleted", "rows_loaded", "bytes", "duration_ms", "forced", "truncated",
+		"config_hash", "operation", "rolled_back_run_id", "recorded_at"}
+	notRolledBack := `AND run_id NOT IN \( SELECT rolled_back_run_id FROM load_ledger ` +
+		`WHERE schema_name = 's' AND table_name = 't' AND operation = 'rollback' \) ORDER BY recorded_at DESC`
+
+	mock.ExpectQuery(`SELECT run_id, .* FROM load_ledger WHERE schema_name = 's' AND table_name = 't' AND operation = 'load' ` +
+		`AND data_date = '2020-01-02 00:00:00' ` + notRolledBack).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("bad", "s", "t", "s3://b/t2.json", date, "day", "time", date, end, 3, 5, 10, 1500, false, false, "abc", "load", "", recorded))
+	bad, err := mockRedshift.FindLoad("s", "t", "", date)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, LoadRecord{
+		RunID: "bad", Operation: OperationLoad, Schema: "s", Table: "t", Source: "s3://b/t2.json", DataDate: date,
+		Granularity: "day", DataDateColumn: "time", WindowStart: &date, WindowEnd: &end, RowsDeleted: 3, RowsLoaded: 5,
+		Bytes: 10, Duration: 1500 * time.Millisecond, ConfigHash: "abc", RecordedAt: recorded,
+	}, *bad)
+
+	// the previous load of the window is the latest one before it, if there is one
+	mock.ExpectQuery(`SELECT run_id, .* FROM load_ledger WHERE .* AND window_start = '2020-01-02 00:00:00' ` +
+		`AND window_end = '2020-01-03 00:00:00' AND recorded_at < '2020-01-02 01:00:00' AND run_id != 'bad' ` + notRolledBack).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("good", "s", "t", "s3://b/t1.json", date, "day", "time", date, end, 0, 4, 8, 1000, false, false, "abc", "load", "", date))
+	previous, err := mockRedshift.PreviousLoad(*bad)
+	if assert.NoError(t, err) && assert.NotNil(t, previous) {
+		assert.Equal(t, "good", previous.RunID)
+		assert.Equal(t, "s3://b/t1.json", previous.Source)
+	}
+	mock.ExpectQuery(`SELECT run_id, .* AND run_id != 'bad'`).WillReturnRows(sqlmock.NewRows(columns))
+	previous, err = mockRedshift.PreviousLoad(*bad)
+	assert.NoError(t, err)
+	assert.Nil(t, previous)
+
+	// loads without a window can't be matched to previous ones
+	_, err = mockRedshift.PreviousLoad(LoadRecord{Schema: "s", Table: "t", Truncated: true})
+	assert.Error(t, err)
+
+	// a later load of the window replaced it
+	mock.ExpectQuery(`SELECT run_id, .* FROM load_ledger WHERE .* AND window_start = '2020-01-02 00:00:00' ` +
+		`AND window_end = '2020-01-03 00:00:00' AND recorded_at > '2020-01-02 01:00:00' AND run_id != 'bad' ` + notRolledBack).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("fixed", "s", "t", "s3://b/t3.json", date, "day", "time", date, end, 5, 6, 12, 1000, false, false, "abc", "load", "", recorded.Add(time.Hour)))
+	later, err := mockRedshift.LaterLoad(*bad)
+	if assert.NoError(t, err) && assert.NotNil(t, later) {
+		assert.Equal(t, "fixed", later.RunID)
+	}
+	mock.ExpectQuery(`SELECT run_id, .* AND recorded_at > .* AND run_id != 'bad'`).WillReturnRows(sqlmock.NewRows(columns))
+	later, err = mockRedshift.LaterLoad(*bad)
+	assert.NoError(t, err)
+	assert.Nil(t, later)
+
+	mock.ExpectQuery(`SELECT run_id, .* AND run_id = 'nope'`).WillReturnRows(sqlmock.NewRows(columns))
+	_, err = mockRedshift.FindLoad("s", "t", "nope", time.Time{})
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestForgetLoadedFiles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS loaded_files`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM loaded_files WHERE schema_name = 's' AND table_name = 't' AND run_id = 'bad'`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.ForgetLoadedFiles(tx, "s", "t", "bad"))
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
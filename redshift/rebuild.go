@@ -0,0 +1,281 @@
+package redshift
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// aclPrivileges maps the privilege letters of a redshift aclitem to the privileges to grant,
+// in the order they're granted. See https://www.postgresql.org/docs/8.0/static/sql-grant.html
+// and https://docs.aws.amazon.com/redshift/latest/dg/r_GRANT.html
+var aclPrivileges = []struct {
+	letter    rune
+	privilege string
+}{
+	{'r', "SELECT"},
+	{'a', "INSERT"},
+	{'w', "UPDATE"},
+	{'d', "DELETE"},
+	{'D', "DROP"},
+	{'R', "RULE"},
+	{'x', "REFERENCES"},
+	{'t', "TRIGGER"},
+}
+
+// aclGrantOption follows a privilege letter in an aclitem if it was granted WITH GRANT OPTION
+const aclGrantOption = '*'
+
+const grantsQueryFormat = `SELECT pg_get_userbyid(c.relowner), array_to_string(c.relacl, '\n')
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = '%s' AND c.relname = '%s'`
+
+// dependentViewsQueryFormat finds the ordinary views that depend on a table, with how many other
+// views depend on each of them in turn. Late-binding views don't depend on the table, so aren't found.
+const dependentViewsQueryFormat = `SELECT DISTINCT v.schemaname, v.viewname, v.definition,
+		(SELECT count(*) FROM pg_depend vd JOIN pg_rewrite vrw ON vrw.oid = vd.objid
+			WHERE vd.refobjid = vc.oid AND vrw.ev_class <> vc.oid) AS dependents
+	FROM pg_depend d
+	JOIN pg_rewrite rw ON rw.oid = d.objid
+	JOIN pg_class vc ON vc.oid = rw.ev_class
+	JOIN pg_namespace vn ON vn.oid = vc.relnamespace
+	JOIN pg_views v ON v.schemaname = vn.nspname AND v.viewname = vc.relname
+	JOIN pg_class tc ON tc.oid = d.refobjid
+	JOIN pg_namespace tn ON tn.oid = tc.relnamespace
+	WHERE tn.nspname = '%s' AND tc.relname = '%s' AND vc.oid <> tc.oid`
+
+// dependentView is an ordinary view on a table, which has to be dropped and recreated for the
+// table to be swapped out
+type dependentView struct {
+	schema, name, definition, owner string
+	grants                          []string
+}
+
+// checkRebuild returns an error if the target table can't be rebuilt in the shape of the input
+// table without losing data: every existing column has to be in the input table, with a type
+// that's the same or wider, and otherwise only sort and dist keys or the column order can differ.
+func checkRebuild(inputTable, targetTable Table) error {
+	var errors error
+	for _, targetCol := range targetTable.Columns {
+		inCol, ok := findColumn(inputTable, targetCol.Name)
+		if !ok {
+			errors = multierror.Append(errors, fmt.Errorf("column %s isn't in the input table and would be dropped", targetCol.Name))
+			continue
+		}
+		// keys are rebuilt from the input table, so they can't mismatch
+		targetCol.DistKey, targetCol.SortOrdinal = inCol.DistKey, inCol.SortOrdinal
+		if err := checkColumn(inCol, targetCol); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+		// the new table has the input's types, so unlike loading, a narrower one would narrow the column
+		inType, inErr := parseType(inCol.Type)
+		targetType, targetErr := parseType(targetCol.Type)
+		if inErr == nil && targetErr == nil && compareTypes(inType, targetType) == typeNarrower {
+			errors = multierror.Append(errors, fmt.Errorf("column %s would be narrowed from %s to %s", targetCol.Name, targetType, inType))
+		}
+	}
+	return errors
+}
+
+func findColumn(table Table, name string) (ColInfo, bool) {
+	for _, c := range table.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ColInfo{}, false
+}
+
+// rebuildTable deep copies the target table into a new table made from the input table, then
+// swaps it in for the original, keeping the original's grants and owner. This all happens in the transaction
+// provided, so readers see either the old table or the new one.
+// Late-binding views refer to the table by name and so pick up the new table. Ordinary views on it
+// are dropped and recreated from their definitions with their grants, unless other views depend on
+// them, in which case it's an error before anything is changed.
+func (r *Redshift) rebuildTable(tx *sql.Tx, inputTable, targetTable Table) error {
+	schema := targetTable.Meta.Schema
+	newTable := inputTable
+	newTable.Name = targetTable.Name + "_new"
+	oldName := targetTable.Name + "_old"
+
+	views, err := r.dependentViews(tx, schema, targetTable.Name)
+	if err != nil {
+		return err
+	}
+	owner, grants, err := r.tableGrants(tx, schema, targetTable.Name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Rebuilding %s.%s", schema, targetTable.Name)
+	if err := r.CreateTable(tx, newTable); err != nil {
+		return fmt.Errorf("issue creating %s.%s: %s", schema, newTable.Name, err)
+	}
+
+	// copy over the columns that already exist, new ones get their defaults
+	var cols []string
+	for _, c := range inputTable.Columns {
+		if _, ok := findColumn(targetTable, c.Name); ok {
+			cols = append(cols, fmt.Sprintf(`"%s"`, c.Name))
+		}
+	}
+	colList := strings.Join(cols, ", ")
+
+	statements := []string{
+		fmt.Sprintf(`INSERT INTO "%s"."%s" (%s) SELECT %s FROM "%s"."%s"`,
+			schema, newTable.Name, colList, colList, schema, targetTable.Name),
+	}
+	for _, grant := range grants {
+		statements = append(statements, fmt.Sprintf(grant, fmt.Sprintf(`"%s"."%s"`, schema, newTable.Name)))
+	}
+	for _, v := range views {
+		statements = append(statements, fmt.Sprintf(`DROP VIEW "%s"."%s"`, v.schema, v.name))
+	}
+	statements = append(statements,
+		fmt.Sprintf(`ALTER TABLE "%s"."%s" RENAME TO "%s"`, schema, targetTable.Name, oldName),
+		fmt.Sprintf(`ALTER TABLE "%s"."%s" RENAME TO "%s"`, schema, newTable.Name, targetTable.Name),
+		fmt.Sprintf(`DROP TABLE "%s"."%s"`, schema, oldName),
+		// the new table is ours until it's handed back, since only its owner can rename it
+		ownerSQL(fmt.Sprintf(`"%s"."%s"`, schema, targetTable.Name), owner),
+	)
+	for _, v := range views {
+		name := fmt.Sprintf(`"%s"."%s"`, v.schema, v.name)
+		statements = append(statements, fmt.Sprintf(`CREATE VIEW %s AS %s`, name, strings.TrimSuffix(strings.TrimSpace(v.definition), ";")))
+		for _, grant := range v.grants {
+			statements = append(statements, fmt.Sprintf(grant, name))
+		}
+		statements = append(statements, ownerSQL(name, v.owner))
+	}
+	for _, q := range statements {
+		if r.skip(q, false) {
+			continue
+		}
+		log.Printf("Running command: %s", q)
+		if _, err := tx.ExecContext(r.ctx, q); err != nil {
+			return fmt.Errorf("issue running statement %s: %s", q, err)
+		}
+	}
+	return nil
+}
+
+// dependentViews returns the ordinary views on a table with their grants, or an error if any of them
+// has views of its own depending on it, which dropping it would break
+func (r *Redshift) dependentViews(tx *sql.Tx, schema, table string) ([]dependentView, error) {
+	rows, err := tx.QueryContext(r.ctx, fmt.Sprintf(dependentViewsQueryFormat, schema, table))
+	if err != nil {
+		return nil, fmt.Errorf("issue looking up views on %s.%s: %s", schema, table, err)
+	}
+	defer rows.Close()
+	var views []dependentView
+	for rows.Next() {
+		var v dependentView
+		var dependents int
+		if err := rows.Scan(&v.schema, &v.name, &v.definition, &dependents); err != nil {
+			return nil, fmt.Errorf("issue reading views on %s.%s: %s", schema, table, err)
+		}
+		if dependents > 0 {
+			return nil, fmt.Errorf("can't rebuild %s.%s: other views depend on its view %s.%s, which would have to be dropped",
+				schema, table, v.schema, v.name)
+		}
+		views = append(views, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("issue reading views on %s.%s: %s", schema, table, err)
+	}
+	// the connection can't run another query until the rows are closed
+	rows.Close()
+	for i, v := range views {
+		if views[i].owner, views[i].grants, err = r.tableGrants(tx, v.schema, v.name); err != nil {
+			return nil, err
+		}
+	}
+	return views, nil
+}
+
+// tableGrants returns a table's owner and GRANT statements recreating its privileges, with a %s in
+// place of the table. Privileges it doesn't know how to grant are an error rather than dropped.
+func (r *Redshift) tableGrants(tx *sql.Tx, schema, table string) (string, []string, error) {
+	var owner, acl sql.NullString
+	if err := tx.QueryRowContext(r.ctx, fmt.Sprintf(grantsQueryFormat, schema, table)).Scan(&owner, &acl); err != nil {
+		return "", nil, fmt.Errorf("issue reading grants on %s.%s: %s", schema, table, err)
+	}
+	var grants []string
+	for _, item := range strings.Split(acl.String, "\n") {
+		itemGrants, err := aclGrants(item)
+		if err != nil {
+			return "", nil, fmt.Errorf("can't keep the grants on %s.%s: %s", schema, table, err)
+		}
+		grants = append(grants, itemGrants...)
+	}
+	return owner.String, grants, nil
+}
+
+// aclGrants turns an aclitem such as "group analysts=r*w/owner" into GRANT statements, one for
+// the privileges granted with grant option and one for the rest
+func aclGrants(item string) ([]string, error) {
+	if item == "" {
+		return nil, nil
+	}
+	eq, slash := strings.Index(item, "="), strings.LastIndex(item, "/")
+	if eq < 0 || slash < eq {
+		return nil, fmt.Errorf("can't read aclitem %q", item)
+	}
+	letters := map[rune]bool{}
+	var last rune
+	for _, c := range item[eq+1 : slash] {
+		if c == aclGrantOption && last != 0 {
+			letters[last] = true
+			continue
+		}
+		if !isACLPrivilege(c) {
+			return nil, fmt.Errorf("unknown privilege %q in aclitem %q", c, item)
+		}
+		letters[c], last = false, c
+	}
+
+	grantee := strings.Trim(item[:eq], `"`)
+	switch {
+	case grantee == "":
+		grantee = "PUBLIC"
+	case strings.HasPrefix(grantee, "group "):
+		grantee = fmt.Sprintf(`GROUP "%s"`, strings.Trim(strings.TrimPrefix(grantee, "group "), `"`))
+	default:
+		grantee = fmt.Sprintf(`"%s"`, grantee)
+	}
+	var grants []string
+	for _, grantOption := range []bool{false, true} {
+		var privs []string
+		for _, p := range aclPrivileges {
+			if withOption, ok := letters[p.letter]; ok && withOption == grantOption {
+				privs = append(privs, p.privilege)
+			}
+		}
+		if len(privs) == 0 {
+			continue
+		}
+		grant := fmt.Sprintf("GRANT %s ON %%s TO %s", strings.Join(privs, ", "), grantee)
+		if grantOption {
+			grant += " WITH GRANT OPTION"
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+func isACLPrivilege(letter rune) bool {
+	for _, p := range aclPrivileges {
+		if p.letter == letter {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerSQL returns the statement handing the table, or view, back to its owner
+func ownerSQL(table, owner string) string {
+	return fmt.Sprintf(`ALTER TABLE %s OWNER TO "%s"`, table, owner)
+}
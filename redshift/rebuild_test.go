@@ -0,0 +1,175 @@
+package redshift
+
+import (
+	"testing"
+
+	"github.com/Clever/s3-to-redshift/v3/s3filepath"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAclGrants(t *testing.T) {
+	for item, expected := range map[string][]string{
+		"analyst=r/admin":           {`GRANT SELECT ON %s TO "analyst"`},
+		"group readers=arwdD/admin": {`GRANT SELECT, INSERT, UPDATE, DELETE, DROP ON %s TO GROUP "readers"`},
+		"=r/admin":                  {`GRANT SELECT ON %s TO PUBLIC`},
+		`"odd name"=x/admin`:        {`GRANT REFERENCES ON %s TO "odd name"`},
+		"lead=r*aw*/admin": {
+			`GRANT INSERT ON %s TO "lead"`,
+			`GRANT SELECT, UPDATE ON %s TO "lead" WITH GRANT OPTION`,
+		},
+		"":               nil,
+		"analyst=/admin": nil,
+	} {
+		grants, err := aclGrants(item)
+		assert.NoError(t, err, item)
+		assert.Equal(t, expected, grants, item)
+	}
+	// a privilege we can't regrant fails the rebuild rather than being dropped
+	for _, item := range []string{"garbage", "analyst=rZ/admin", "analyst=*r/admin"} {
+		_, err := aclGrants(item)
+		assert.Error(t, err, item)
+	}
+}
+
+func TestCheckRebuild(t *testing.T) {
+	target := Table{Name: "t", Columns: []ColInfo{
+		{Name: "a", Type: "integer", SortOrdinal: 1},
+		{Name: "b", Type: "character varying(256)"},
+	}}
+
+	// reordered, rekeyed and widened columns can be rebuilt
+	assert.NoError(t, checkRebuild(Table{Name: "t", Columns: []ColInfo{
+		{Name: "b", Type: "varchar(512)", DistKey: true},
+		{Name: "a", Type: "bigint", SortOrdinal: 2},
+		{Name: "c", Type: "boolean"},
+	}}, target))
+
+	// but not dropped or narrowed ones
+	assert.Error(t, checkRebuild(Table{Name: "t", Columns: []ColInfo{
+		{Name: "b", Type: "varchar(512)"},
+	}}, target))
+	assert.Error(t, checkRebuild(Table{Name: "t", Columns: []ColInfo{
+		{Name: "a", Type: "smallint"},
+		{Name: "b", Type: "varchar(512)"},
+	}}, target))
+}
+
+func TestUpdateTableRebuild(t *testing.T) {
+	schema, table := "testschema", "tablename"
+	inputTable := Table{
+		Name: table,
+		Columns: []ColInfo{
+			{"id", "text", "", false, false, true, 0, "", ""},
+			{"time", "timestamp", "", false, false, false, 1, "", ""},
+			{"extra", "boolean", "", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: schema, Rebuild: true},
+	}
+	targetTable := Table{
+		Name: table,
+		Columns: []ColInfo{
+			{"time", "timestamp without time zone", "", false, false, false, 1, "", ""},
+			{"id", "character varying(256)", "", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: schema},
+	}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT DISTINCT v.schemaname, v.viewname`).WillReturnRows(sqlmock.NewRows(viewColumns))
+	mock.ExpectQuery(`SELECT pg_get_userbyid\(c.relowner\), array_to_string\(c.relacl`).
+		WillReturnRows(sqlmock.NewRows(grantColumns).AddRow("admin", "admin=arwdDRxt/admin\ngroup readers=r/admin"))
+	mock.ExpectPrepare("This needs to be here, but not evaluated")
+	mock.ExpectExec(`CREATE TABLE "testschema"."tablename_new" \( "id" .*, "time" .*, "extra" .*\) DISTSTYLE KEY DISTKEY\("id"\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, regex := range []string{
+		`INSERT INTO "testschema"."tablename_new" \("id", "time"\) SELECT "id", "time" FROM "testschema"."tablename"`,
+		`GRANT SELECT, INSERT, UPDATE, DELETE, DROP, RULE, REFERENCES, TRIGGER ON "testschema"."tablename_new" TO "admin"`,
+		`GRANT SELECT ON "testschema"."tablename_new" TO GROUP "readers"`,
+		`ALTER TABLE "testschema"."tablename" RENAME TO "tablename_old"`,
+		`ALTER TABLE "testschema"."tablename_new" RENAME TO "tablename"`,
+		`DROP TABLE "testschema"."tablename_old"`,
+		`ALTER TABLE "testschema"."tablename" OWNER TO "admin"`,
+	} {
+		mock.ExpectExec(regex).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.UpdateTable(tx, s3filepath.S3File{}, inputTable, targetTable))
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// without the rebuild policy it's still an error
+	inputTable.Meta.Rebuild = false
+	_, err = checkSchemas(inputTable, targetTable, false)
+	assert.Error(t, err)
+}
+
+var (
+	viewColumns  = []string{"schemaname", "viewname", "definition", "dependents"}
+	grantColumns = []string{"owner", "acl"}
+)
+
+func TestRebuildTableViews(t *testing.T) {
+	schema, table := "testschema", "tablename"
+	inputTable := Table{Name: table, Columns: []ColInfo{{Name: "id", Type: "integer", DistKey: true, SortOrdinal: 1}}, Meta: Meta{Schema: schema}}
+	targetTable := Table{Name: table, Columns: []ColInfo{{Name: "id", Type: "integer"}}, Meta: Meta{Schema: schema}}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	// views on the table are dropped before the swap and recreated after it, with their grants
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT DISTINCT v.schemaname, v.viewname.* WHERE tn.nspname = 'testschema' AND tc.relname = 'tablename'`).
+		WillReturnRows(sqlmock.NewRows(viewColumns).AddRow("reports", "ids", " SELECT tablename.id FROM testschema.tablename;", 0))
+	mock.ExpectQuery(`SELECT pg_get_userbyid\(c.relowner\), array_to_string\(c.relacl.* WHERE n.nspname = 'reports' AND c.relname = 'ids'`).
+		WillReturnRows(sqlmock.NewRows(grantColumns).AddRow("analyst", "group readers=r/admin"))
+	mock.ExpectQuery(`SELECT pg_get_userbyid\(c.relowner\), array_to_string\(c.relacl.* WHERE n.nspname = 'testschema' AND c.relname = 'tablename'`).
+		WillReturnRows(sqlmock.NewRows(grantColumns).AddRow("admin", nil))
+	mock.ExpectPrepare("This needs to be here, but not evaluated")
+	mock.ExpectExec(`CREATE TABLE "testschema"."tablename_new"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, regex := range []string{
+		`INSERT INTO "testschema"."tablename_new" \("id"\) SELECT "id" FROM "testschema"."tablename"`,
+		`DROP VIEW "reports"."ids"`,
+		`ALTER TABLE "testschema"."tablename" RENAME TO "tablename_old"`,
+		`ALTER TABLE "testschema"."tablename_new" RENAME TO "tablename"`,
+		`DROP TABLE "testschema"."tablename_old"`,
+		`ALTER TABLE "testschema"."tablename" OWNER TO "admin"`,
+		`CREATE VIEW "reports"."ids" AS SELECT tablename.id FROM testschema.tablename$`,
+		`GRANT SELECT ON "reports"."ids" TO GROUP "readers"`,
+		`ALTER TABLE "reports"."ids" OWNER TO "analyst"`,
+	} {
+		mock.ExpectExec(regex).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.rebuildTable(tx, inputTable, targetTable))
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// views on those views can't be recreated, so nothing is done
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT DISTINCT v.schemaname, v.viewname`).
+		WillReturnRows(sqlmock.NewRows(viewColumns).AddRow("reports", "ids", "SELECT 1", 1))
+	mock.ExpectRollback()
+
+	tx, err = mockRedshift.Begin()
+	assert.NoError(t, err)
+	err = mockRedshift.rebuildTable(tx, inputTable, targetTable)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "reports.ids")
+	}
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
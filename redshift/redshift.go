@@ -6,14 +6,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	kvlogger "gopkg.in/Clever/kayvee-go.v6/logger"
 	yaml "gopkg.in/yaml.v2"
 
-	"github.com/Clever/pathio"
 	multierror "github.com/hashicorp/go-multierror"
 
 	// Use our own version of the postgres library so we get keep-alive support.
@@ -41,6 +40,17 @@ type Redshift struct {
 	port string
 	db   string
 	user string
+
+	// dryRun records the statements that would change the database in planned rather than running them
+	dryRun  bool
+	planned []PlannedStatement
+}
+
+// PlannedStatement is a statement that a dry run would have run
+type PlannedStatement struct {
+	SQL string `json:"sql"`
+	// OutsideTx is set for statements that aren't run in the load's transaction
+	OutsideTx bool `json:"outside_transaction,omitempty"`
 }
 
 // Table is our representation of a Redshift table
@@ -56,6 +66,19 @@ type Table struct {
 type Meta struct {
 	DataDateColumn string `yaml:"datadatecolumn"`
 	Schema         string `yaml:"schema"`
+	// Mode is how new data is loaded into the table, one of ModeAppend (the default) or ModeMerge
+	Mode string `yaml:"mode,omitempty"`
+	// SortStyle is the kind of sort key made from the columns with a sortord,
+	// SortCompound (the default) or SortInterleaved
+	SortStyle string `yaml:"sortstyle,omitempty"`
+	// DistStyle is how rows are spread across nodes, one of DistKey (the default
+	// when a column is the distkey), DistAll, DistEven or DistAuto
+	DistStyle string `yaml:"diststyle,omitempty"`
+	// Rebuild allows deep copying an existing table into a new one when its sort and dist keys
+	// or column order don't match the config, rather than failing the load
+	Rebuild bool `yaml:"rebuild,omitempty"`
+	// Copy overrides or extends the options the table's input is loaded with
+	Copy CopyOptions `yaml:"copy,omitempty"`
 }
 
 // ColInfo is a struct that contains information about a column in a Redshift database.
@@ -63,13 +86,37 @@ type Meta struct {
 type ColInfo struct {
 	Name        string `yaml:"dest"`
 	Type        string `yaml:"type"`
-	DefaultVal  string `yaml:"defaultval"`
-	NotNull     bool   `yaml:"notnull"`
-	PrimaryKey  bool   `yaml:"primarykey"`
-	DistKey     bool   `yaml:"distkey"`
-	SortOrdinal int    `yaml:"sortord"`
+	DefaultVal  string `yaml:"defaultval,omitempty"`
+	NotNull     bool   `yaml:"notnull,omitempty"`
+	PrimaryKey  bool   `yaml:"primarykey,omitempty"`
+	DistKey     bool   `yaml:"distkey,omitempty"`
+	SortOrdinal int    `yaml:"sortord,omitempty"`
+	// Source is the JSONPath expression of the field the column is loaded from, e.g. $.user.id,
+	// if it isn't the top level field named like the column. See Table.JSONPaths.
+	Source string `yaml:"source,omitempty"`
+	// Encoding is the column's compression encoding, as read from redshift. Configs don't set it.
+	Encoding string `yaml:"-"`
 }
 
+const (
+	// ModeAppend clears out the data date's time range (or the whole table, if truncating)
+	// and then appends the input data
+	ModeAppend = "append"
+	// ModeMerge upserts the input data, replacing existing rows that share a primary key
+	ModeMerge = "merge"
+)
+
+// Supported values of Meta.SortStyle and Meta.DistStyle
+const (
+	SortCompound    = "compound"
+	SortInterleaved = "interleaved"
+
+	DistKey  = "key"
+	DistAll  = "all"
+	DistEven = "even"
+	DistAuto = "auto"
+)
+
 type rangeQuery int
 
 const (
@@ -102,7 +149,8 @@ const (
   f.attnotnull AS not_null,
   p.contype IS NOT NULL AS primary_key,
   f.attisdistkey AS dist_key,
-  f.attsortkeyord AS sort_ord
+  f.attsortkeyord AS sort_ord,
+  format_encoding(f.attencodingtype::integer) AS encoding
 FROM pg_attribute f
   JOIN pg_class c ON c.oid = f.attrelid
   LEFT JOIN pg_attrdef d ON d.adrelid = c.oid AND d.adnum = f.attnum
@@ -112,17 +160,96 @@ WHERE c.relkind = 'r'::char
     AND n.nspname = '%s'  -- Replace with schema name
     AND c.relname = '%s'  -- Replace with table name
      AND f.attnum > 0 ORDER BY f.attnum`
+
+	// returns the table's distribution style, see
+	// https://docs.aws.amazon.com/redshift/latest/dg/r_PG_CLASS_INFO.html
+	// need to pass a schema and table name as the parameters
+	distStyleQueryFormat = `SELECT c.reldiststyle
+FROM pg_class c
+  JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = '%s' AND c.relname = '%s'`
+
+	// returns the table's first sort key column, or INTERLEAVED for interleaved
+	// sort keys, see https://docs.aws.amazon.com/redshift/latest/dg/r_SVV_TABLE_INFO.html
+	// empty tables aren't in svv_table_info, so this may return no rows
+	// need to pass a schema and table name as the parameters
+	sortStyleQueryFormat = `SELECT sortkey1 FROM svv_table_info WHERE "schema" = '%s' AND "table" = '%s'`
+
+	// returns one row per line rejected by the last failed COPY of a session, with the attributes:
+	// query, filename, line_number, column, raw_field_value, err_code and err_reason
+	// need to pass a session id and a row limit as the parameters
+	loadErrorsQueryFormat = `SELECT
+  e.query,
+  TRIM(e.filename) AS filename,
+  e.line_number,
+  TRIM(e.colname) AS colname,
+  COALESCE(NULLIF(TRIM(e.raw_field_value), ''), TRIM(d.value), '') AS raw_field_value,
+  e.err_code,
+  TRIM(e.err_reason) AS err_reason
+FROM stl_load_errors e
+  LEFT JOIN stl_loaderror_detail d ON d.query = e.query AND d.slice = e.slice
+    AND d.line_number = e.line_number AND d.colname = e.colname
+WHERE e.query = (SELECT MAX(query) FROM stl_load_errors WHERE session = %d)
+ORDER BY e.filename, e.line_number
+LIMIT %d`
+
+	// we only report the first few rejected lines, they're usually all wrong in the same way
+	maxLoadErrorRows = 10
 )
 
+// LoadError is returned when a COPY fails because redshift rejected some of the input,
+// with the details redshift recorded in stl_load_errors and stl_loaderror_detail
+type LoadError struct {
+	Target string
+	Query  int64
+	Rows   []LoadErrorRow
+	// Err is the error returned by the COPY itself
+	Err error
+}
+
+// LoadErrorRow is a single line of input rejected by a COPY
+type LoadErrorRow struct {
+	Filename      string `json:"filename"`
+	LineNumber    int64  `json:"line_number"`
+	Column        string `json:"column"`
+	RawFieldValue string `json:"raw_field_value"`
+	ErrCode       int    `json:"err_code"`
+	ErrReason     string `json:"err_reason"`
+}
+
+func (e *LoadError) Error() string {
+	var rows []string
+	for _, row := range e.Rows {
+		rows = append(rows, fmt.Sprintf("%s line %d column %s value %q: %s (code %d)",
+			row.Filename, row.LineNumber, row.Column, row.RawFieldValue, row.ErrReason, row.ErrCode))
+	}
+	return fmt.Sprintf("load into %s failed in query %d: %s: %s", e.Target, e.Query, e.Err, strings.Join(rows, "; "))
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
 var (
-	// map between the config file and the redshift internal representations for types
+	// map between pg_class.reldiststyle and Meta.DistStyle, where the AUTO(...) styles are all just auto
+	distStyleMapping = map[int]string{
+		0:  DistEven,
+		1:  DistKey,
+		8:  DistAll,
+		10: DistAuto,
+		11: DistAuto,
+		12: DistAuto,
+	}
+
+	// map between the config file shorthands and the redshift internal representations for types.
+	// Any type redshift accepts can also be used in the config, see parseType
 	typeMapping = map[string]string{
 		"boolean":   "boolean",
 		"float":     "double precision",
 		"int":       "integer",
 		"bigint":    "bigint",
 		"date":      "date",
-		"timestamp": "timestamp without time zone", // timestamp with timezone is not supported in redshift
+		"timestamp": "timestamp without time zone", // use timestamptz for timestamp with time zone
 		"text":      "character varying(256)",      // unfortunately redshift turns text -> varchar 256
 		"longtext":  "character varying(65535)",    // when you actually need more than 256 characters
 	}
@@ -152,6 +279,30 @@ func NewRedshift(ctx context.Context, host, port, db, user, password string, tim
 	}, nil
 }
 
+// DryRun makes r record the statements that would change the database instead of running them.
+// Queries that only read, such as looking up table metadata, still run.
+func (r *Redshift) DryRun() {
+	r.dryRun = true
+}
+
+// Planned returns the statements recorded by a dry run since the last call, in order
+func (r *Redshift) Planned() []PlannedStatement {
+	planned := r.planned
+	r.planned = nil
+	return planned
+}
+
+// skip records a statement that would change the database if this is a dry run,
+// in which case it shouldn't be run
+func (r *Redshift) skip(query string, outsideTx bool) bool {
+	if !r.dryRun {
+		return false
+	}
+	log.Printf("Dry run, not running command: %s", query)
+	r.planned = append(r.planned, PlannedStatement{SQL: strings.Join(strings.Fields(query), " "), OutsideTx: outsideTx})
+	return true
+}
+
 // Begin wraps a new transaction in the databases context
 func (r *Redshift) Begin() (*sql.Tx, error) {
 	return r.dbExecCloser.BeginTx(r.ctx, nil)
@@ -164,10 +315,11 @@ func (r *Redshift) GetTableFromConf(f s3filepath.S3File) (*Table, error) {
 	var tempSchema map[string]Table
 
 	log.Printf("Parsing file: %s", f.ConfFile)
-	reader, err := pathio.Reader(f.ConfFile)
+	reader, err := f.ConfReader().Reader(f.ConfFile)
 	if err != nil {
 		return nil, fmt.Errorf("error opening conf file: %s", err)
 	}
+	defer reader.Close()
 	data, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, err
@@ -185,6 +337,23 @@ func (r *Redshift) GetTableFromConf(f s3filepath.S3File) (*Table, error) {
 			if config.Meta.DataDateColumn == "" {
 				return nil, fmt.Errorf("data date column must be set")
 			}
+			if config.Meta.Mode != "" && config.Meta.Mode != ModeAppend && config.Meta.Mode != ModeMerge {
+				return nil, fmt.Errorf("unsupported mode %q, must be one of %s or %s", config.Meta.Mode, ModeAppend, ModeMerge)
+			}
+			if _, err := tableAttributesSQL(config); err != nil {
+				return nil, err
+			}
+			if err := checkCopyOptions(config.Meta.Copy); err != nil {
+				return nil, err
+			}
+			for _, c := range config.Columns {
+				if _, err := parseType(c.Type); err != nil {
+					return nil, fmt.Errorf("column %s: %s", c.Name, err)
+				}
+				if err := checkSource(c.Source); err != nil {
+					return nil, fmt.Errorf("column %s: %s", c.Name, err)
+				}
+			}
 
 			return &config, nil
 		}
@@ -196,7 +365,25 @@ func (r *Redshift) GetTableFromConf(f s3filepath.S3File) (*Table, error) {
 // of the db table and the last data in the table, if that exists
 // if the table does not exist it returns an empty table but does not error
 func (r *Redshift) GetTableMetadata(schema, tableName, dataDateCol string) (*Table, *time.Time, error) {
+	retTable, err := r.getTable(schema, tableName)
+	if err != nil || retTable == nil {
+		return nil, nil, err
+	}
+	retTable.Meta.DataDateColumn = dataDateCol
+
+	// what's the last data in the table?
+	lastData, err := r.MaxTime(fmt.Sprintf(`"%s"."%s"`, schema, tableName), dataDateCol)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return retTable, &lastData, nil
+}
+
+// getTable reads the columns, keys and styles of a table, or returns nil if it doesn't exist
+func (r *Redshift) getTable(schema, tableName string) (*Table, error) {
 	var cols []ColInfo
+	var sortStyle string
 
 	// does the table exist?
 	var placeholder string
@@ -207,48 +394,70 @@ func (r *Redshift) GetTableMetadata(schema, tableName, dataDateCol string) (*Tab
 		// The correct behavior is to create a new table.
 		if err == sql.ErrNoRows {
 			log.Printf("schema: %s, table: %s does not exist", schema, tableName)
-			return nil, nil, nil
+			return nil, nil
 		}
-		return nil, nil, fmt.Errorf("issue just checking if the table exists: %s", err)
+		return nil, fmt.Errorf("issue just checking if the table exists: %s", err)
 	}
 
 	// table exists, what are the columns?
 	rows, err := r.QueryContext(r.ctx, fmt.Sprintf(schemaQueryFormat, schema, tableName))
 	if err != nil {
-		return nil, nil, fmt.Errorf("issue running column query: %s, err: %s", schemaQueryFormat, err)
+		return nil, fmt.Errorf("issue running column query: %s, err: %s", schemaQueryFormat, err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var c ColInfo
 		if err := rows.Scan(&c.Name, &c.Type, &c.DefaultVal, &c.NotNull,
-			&c.PrimaryKey, &c.DistKey, &c.SortOrdinal,
+			&c.PrimaryKey, &c.DistKey, &c.SortOrdinal, &c.Encoding,
 		); err != nil {
-			return nil, nil, fmt.Errorf("issue scanning column, err: %s", err)
+			return nil, fmt.Errorf("issue scanning column, err: %s", err)
+		}
+		// interleaved sort keys alternate their ordinals' signs (1, -2, 3, ...), so the
+		// signs only tell the style apart when there's more than one sort column
+		if c.SortOrdinal < 0 {
+			c.SortOrdinal = -c.SortOrdinal
+			sortStyle = SortInterleaved
+		} else if c.SortOrdinal > 0 && sortStyle == "" {
+			sortStyle = SortCompound
 		}
 
 		cols = append(cols, c)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, nil, fmt.Errorf("issue iterating over columns, err: %s", err)
+		return nil, fmt.Errorf("issue iterating over columns, err: %s", err)
+	}
+
+	var relDistStyle int
+	q = fmt.Sprintf(distStyleQueryFormat, schema, tableName)
+	if err := r.QueryRowContext(r.ctx, q).Scan(&relDistStyle); err != nil {
+		return nil, fmt.Errorf("issue running dist style query: %s, err: %s", q, err)
+	}
+
+	if sortStyle != "" {
+		var sortKey sql.NullString
+		q = fmt.Sprintf(sortStyleQueryFormat, schema, tableName)
+		switch err := r.QueryRowContext(r.ctx, q).Scan(&sortKey); {
+		case err == sql.ErrNoRows:
+			// empty tables aren't listed, so fall back to the ordinals' signs
+		case err != nil:
+			return nil, fmt.Errorf("issue running sort style query: %s, err: %s", q, err)
+		case strings.EqualFold(strings.TrimSpace(sortKey.String), "INTERLEAVED"):
+			sortStyle = SortInterleaved
+		default:
+			sortStyle = SortCompound
+		}
 	}
 
 	// turn into Table struct
-	retTable := Table{
+	return &Table{
 		Name:    tableName,
 		Columns: cols,
 		Meta: Meta{
-			DataDateColumn: dataDateCol,
-			Schema:         schema,
+			Schema:    schema,
+			SortStyle: sortStyle,
+			DistStyle: distStyleMapping[relDistStyle],
 		},
-	}
-
-	// what's the last data in the table?
-	lastData, err := r.MaxTime(fmt.Sprintf(`"%s"."%s"`, schema, tableName), dataDateCol)
-
-	if err != nil {
-		return nil, nil, err
-	}
-	return &retTable, &lastData, nil
+	}, nil
 }
 
 // MaxTime returns the maximum value for the time field in the specified table
@@ -282,8 +491,7 @@ func (r *Redshift) maxTime(fullName, dataDateCol string, rangeLimit rangeQuery)
 }
 
 func getColumnSQL(c ColInfo) string {
-	// note that we are relying on redshift to fail if we have created multiple sort keys
-	// currently we don't support that
+	// sort and dist keys are table attributes, see tableAttributesSQL
 	defaultVal := ""
 	if c.DefaultVal != "" {
 		defaultVal = fmt.Sprintf("DEFAULT %s", c.DefaultVal)
@@ -296,16 +504,93 @@ func getColumnSQL(c ColInfo) string {
 	if c.PrimaryKey {
 		primaryKey = "PRIMARY KEY"
 	}
-	sortKey := ""
-	if c.SortOrdinal == 1 {
-		sortKey = "SORTKEY"
+
+	return fmt.Sprintf(" \"%s\" %s %s %s %s", c.Name, typeSQL(c.Type), defaultVal, notNull, primaryKey)
+}
+
+// sortColumns returns the names of the table's sort key columns, in sort key order
+func sortColumns(table Table) []string {
+	cols := []ColInfo{}
+	for _, c := range table.Columns {
+		if c.SortOrdinal > 0 {
+			cols = append(cols, c)
+		}
+	}
+	sort.SliceStable(cols, func(i, j int) bool { return cols[i].SortOrdinal < cols[j].SortOrdinal })
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// distColumns returns the names of the table's dist key columns, which should be at most one
+func distColumns(table Table) []string {
+	var names []string
+	for _, c := range table.Columns {
+		if c.DistKey {
+			names = append(names, c.Name)
+		}
 	}
-	distKey := ""
-	if c.DistKey {
-		distKey = "DISTKEY"
+	return names
+}
+
+// sortStyle returns the table's sort style, defaulting to compound if it has sort
+// columns, and "" if it has none
+func sortStyle(table Table) string {
+	if table.Meta.SortStyle != "" || len(sortColumns(table)) == 0 {
+		return table.Meta.SortStyle
 	}
+	return SortCompound
+}
 
-	return fmt.Sprintf(" \"%s\" %s %s %s %s %s %s", c.Name, typeMapping[c.Type], defaultVal, notNull, sortKey, primaryKey, distKey)
+// distStyle returns the table's dist style, defaulting to key if it has a dist key column,
+// and "" if it has none
+func distStyle(table Table) string {
+	if table.Meta.DistStyle != "" || len(distColumns(table)) == 0 {
+		return table.Meta.DistStyle
+	}
+	return DistKey
+}
+
+// tableAttributesSQL returns the DISTSTYLE, DISTKEY and SORTKEY clauses for creating a table,
+// or an error if the table's keys and styles don't make sense together
+func tableAttributesSQL(table Table) (string, error) {
+	var attrs []string
+
+	distCols := distColumns(table)
+	if len(distCols) > 1 {
+		return "", fmt.Errorf("only one distkey column is allowed, found: %v", distCols)
+	}
+	switch style := distStyle(table); style {
+	case "":
+	case DistKey:
+		if len(distCols) == 0 {
+			return "", fmt.Errorf("diststyle %s requires a distkey column", DistKey)
+		}
+		attrs = append(attrs, fmt.Sprintf(`DISTSTYLE KEY DISTKEY("%s")`, distCols[0]))
+	case DistAll, DistEven, DistAuto:
+		if len(distCols) != 0 {
+			return "", fmt.Errorf("diststyle %s can't have a distkey column, found: %v", style, distCols)
+		}
+		attrs = append(attrs, fmt.Sprintf("DISTSTYLE %s", strings.ToUpper(style)))
+	default:
+		return "", fmt.Errorf("unsupported diststyle %q, must be one of %s, %s, %s or %s", style, DistKey, DistAll, DistEven, DistAuto)
+	}
+
+	sortCols := sortColumns(table)
+	switch style := sortStyle(table); style {
+	case "":
+	case SortCompound, SortInterleaved:
+		if len(sortCols) == 0 {
+			return "", fmt.Errorf("sortstyle %s requires at least one column with a sortord", style)
+		}
+		attrs = append(attrs, fmt.Sprintf(`%s SORTKEY("%s")`, strings.ToUpper(style), strings.Join(sortCols, `","`)))
+	default:
+		return "", fmt.Errorf("unsupported sortstyle %q, must be one of %s or %s", style, SortCompound, SortInterleaved)
+	}
+
+	return strings.Join(attrs, " "), nil
 }
 
 // CreateTable runs the full create table command in the provided transaction, given a
@@ -316,13 +601,20 @@ func (r *Redshift) CreateTable(tx *sql.Tx, table Table) error {
 		columnSQL = append(columnSQL, getColumnSQL(c))
 	}
 	args := []interface{}{strings.Join(columnSQL, ",")}
+	attrSQL, err := tableAttributesSQL(table)
+	if err != nil {
+		return fmt.Errorf("issue with table keys: %s", err)
+	}
 	// for some reason prepare here was unable to succeed, perhaps look at this later
-	createSQL := fmt.Sprintf(`CREATE TABLE "%s"."%s" (%s)`, table.Meta.Schema, table.Name, strings.Join(columnSQL, ","))
+	createSQL := fmt.Sprintf(`CREATE TABLE "%s"."%s" (%s) %s`, table.Meta.Schema, table.Name, strings.Join(columnSQL, ","), attrSQL)
 
-	if match, _ := regexp.MatchString("SORTKEY|DISTKEY", createSQL); !match {
-		return fmt.Errorf("both SORTKEY and DISTKEY should be specified in create table: %s. Either create your own table if you truly don't want those keys, or update the config to contain both", createSQL)
+	if attrSQL == "" {
+		return fmt.Errorf("both SORTKEY and DISTKEY should be specified in create table: %s. Either create your own table if you truly don't want those keys, or update the config to contain both (or a diststyle)", createSQL)
 	}
 
+	if r.skip(createSQL, false) {
+		return nil
+	}
 	createStmt, err := tx.PrepareContext(r.ctx, createSQL)
 	if err != nil {
 		return fmt.Errorf("issue preparing statement: %s", err)
@@ -333,49 +625,132 @@ func (r *Redshift) CreateTable(tx *sql.Tx, table Table) error {
 	return err
 }
 
-// UpdateTable figures out what columns we need to add to the target table based on the
-// input table, and completes this action in the transaction provided
-// Note: only supports adding columns currently, not updating existing columns or removing them
-func (r *Redshift) UpdateTable(tx *sql.Tx, inputTable, targetTable Table) error {
+// WidenColumns widens the target table's varchars that are narrower than the input table's in place.
+// Redshift won't run ALTER COLUMN ... TYPE in a transaction block, so this runs on its own and isn't
+// rolled back if the load fails, which leaves the wider columns. It's meant to run once the input is
+// known to be loaded, before the load's transaction starts, since it would wait on that transaction's
+// locks. Anything else that needs changing is left to UpdateTable.
+func (r *Redshift) WidenColumns(f s3filepath.S3File, inputTable, targetTable Table) error {
+	columnOps, err := checkSchemas(inputTable, targetTable, f.IsColumnar())
+	if err != nil {
+		// UpdateTable reports the mismatch, or rebuilds the table instead
+		log.Printf("Not widening columns of %s.%s in place: %s", targetTable.Meta.Schema, targetTable.Name, err)
+		return nil
+	}
+	for _, op := range columnOps {
+		if !op.outsideTx {
+			continue
+		}
+		if r.skip(op.sql, true) {
+			continue
+		}
+		log.Printf("Running command outside of transaction: %s", op.sql)
+		if _, err := r.ExecContext(r.ctx, op.sql); err != nil {
+			return fmt.Errorf("issue running statement %s: %s", op.sql, err)
+		}
+	}
+	return nil
+}
+
+// UpdateTable figures out what columns we need to add to or widen in the target table based on the
+// input table, and completes this action in the transaction provided. Varchars that can be widened in
+// place are left to WidenColumns, which has to have run first.
+// Note: only supports adding and widening columns currently, not narrowing existing columns or removing them.
+// Tables whose Meta allows it are rebuilt instead when their keys or column order don't match.
+func (r *Redshift) UpdateTable(tx *sql.Tx, f s3filepath.S3File, inputTable, targetTable Table) error {
 
-	columnOps, err := checkSchemas(inputTable, targetTable)
+	columnOps, err := checkSchemas(inputTable, targetTable, f.IsColumnar())
 	if err != nil {
-		return fmt.Errorf("mismatched schema: %s", err)
+		if !inputTable.Meta.Rebuild {
+			return fmt.Errorf("mismatched schema: %s", err)
+		}
+		if rebuildErr := checkRebuild(inputTable, targetTable); rebuildErr != nil {
+			return fmt.Errorf("mismatched schema: %s, which a rebuild can't fix: %s", err, rebuildErr)
+		}
+		log.Printf("Mismatched schema, rebuilding table: %s", err)
+		return r.rebuildTable(tx, inputTable, targetTable)
 	}
 
 	// postgres only allows adding one column at a time
 	for _, op := range columnOps {
-		alterStmt, err := tx.PrepareContext(r.ctx, op)
+		if op.outsideTx {
+			continue
+		}
+		if r.skip(op.sql, false) {
+			continue
+		}
+		alterStmt, err := tx.PrepareContext(r.ctx, op.sql)
 		if err != nil {
-			return fmt.Errorf("issue preparing statement: '%s' - err: %s", op, err)
+			return fmt.Errorf("issue preparing statement: '%s' - err: %s", op.sql, err)
 		}
 
-		log.Printf("Running command: %s", op)
+		log.Printf("Running command: %s", op.sql)
 		_, err = alterStmt.ExecContext(r.ctx)
 		if err != nil {
-			return fmt.Errorf("issue running statement %s: %s", op, err)
+			return fmt.Errorf("issue running statement %s: %s", op.sql, err)
 		}
 	}
 	return nil
 }
 
+// columnOp is a statement that brings the target table's columns in line with the input table
+type columnOp struct {
+	sql string
+	// outsideTx is set for statements redshift refuses to run in a transaction block
+	outsideTx bool
+}
+
+// rebuildError is a difference between the input and target tables that can't be
+// applied to the target in place, but could be by rebuilding the table
+type rebuildError struct {
+	column string
+	reason string
+}
+
+func (e rebuildError) Error() string {
+	return fmt.Sprintf("column %s requires a table rebuild: %s", e.column, e.reason)
+}
+
 // checkSchemas takes in two tables and compares their column schemas to make sure they're compatible.
 // If they have any mismatched columns they are returned in the errors array. If the input table has
-// columns at the end that the target table does not then the appropriate alter tables sql commands are
-// returned.
-func checkSchemas(inputTable, targetTable Table) ([]string, error) {
+// columns at the end that the target table does not, or columns whose types are wider than the
+// target's, then the appropriate alter tables sql commands are returned.
+func checkSchemas(inputTable, targetTable Table, columnar bool) ([]columnOp, error) {
 	// If the schema is mongo_raw then we know the input files are json so ordering doesn't matter. At
 	// some point we could handle this in a more general way by checking if the input files are json.
 	// This wouldn't be too hard, but we would have to peak in the manifest file to check if all the
 	// files references are json.
-	if targetTable.Meta.Schema == "mongo_raw" {
-		return checkColumnsWithoutOrdering(inputTable, targetTable)
+	// Columnar (parquet/orc) files name their columns too, so the same goes for them.
+	var columnOps []columnOp
+	var errors error
+	if targetTable.Meta.Schema == "mongo_raw" || columnar {
+		columnOps, errors = checkColumnsWithoutOrdering(inputTable, targetTable)
+	} else {
+		columnOps, errors = checkColumnsAndOrdering(inputTable, targetTable)
+	}
+	if err := checkTableAttributes(inputTable, targetTable); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+	return columnOps, errors
+}
+
+// checkTableAttributes compares the table-wide sort and dist styles. Like per-column keys,
+// it's ok if either side doesn't have them (the sort and dist ordinals of the columns
+// themselves are compared in checkColumn), but they should at least not disagree.
+func checkTableAttributes(inputTable, targetTable Table) error {
+	var errors error
+	mismatchedTemplate := "mismatched table: %s property: %s, input: %v, target: %v"
+	if in, target := sortStyle(inputTable), sortStyle(targetTable); in != "" && target != "" && in != target {
+		errors = multierror.Append(errors, fmt.Errorf(mismatchedTemplate, inputTable.Name, "SortStyle", in, target))
+	}
+	if in, target := distStyle(inputTable), distStyle(targetTable); in != "" && target != "" && in != target {
+		errors = multierror.Append(errors, fmt.Errorf(mismatchedTemplate, inputTable.Name, "DistStyle", in, target))
 	}
-	return checkColumnsAndOrdering(inputTable, targetTable)
+	return errors
 }
 
-func checkColumnsAndOrdering(inputTable, targetTable Table) ([]string, error) {
-	var columnOps []string
+func checkColumnsAndOrdering(inputTable, targetTable Table) ([]columnOp, error) {
+	var columnOps []columnOp
 	var errors error
 	if len(inputTable.Columns) < len(targetTable.Columns) {
 		errors = multierror.Append(errors, fmt.Errorf("target table has more columns than the input table"))
@@ -385,7 +760,7 @@ func checkColumnsAndOrdering(inputTable, targetTable Table) ([]string, error) {
 		if len(targetTable.Columns) <= idx {
 			log.Printf("Missing column -- running alter table\n")
 			alterSQL := fmt.Sprintf(`ALTER TABLE "%s"."%s" ADD COLUMN %s`, targetTable.Meta.Schema, targetTable.Name, getColumnSQL(inCol))
-			columnOps = append(columnOps, alterSQL)
+			columnOps = append(columnOps, columnOp{sql: alterSQL})
 			continue
 		}
 
@@ -393,14 +768,21 @@ func checkColumnsAndOrdering(inputTable, targetTable Table) ([]string, error) {
 		err := checkColumn(inCol, targetCol)
 		if err != nil {
 			errors = multierror.Append(errors, err)
+			continue
 		}
-
+		// swapping a column moves it to the end of the table, which is only ok for the last one
+		// since the input's columns are matched up by position
+		widenOps, err := widenColumn(targetTable, inCol, targetCol, idx == len(targetTable.Columns)-1)
+		if err != nil {
+			errors = multierror.Append(errors, err)
+		}
+		columnOps = append(columnOps, widenOps...)
 	}
 	return columnOps, errors
 }
 
-func checkColumnsWithoutOrdering(inputTable, targetTable Table) ([]string, error) {
-	var columnOps []string
+func checkColumnsWithoutOrdering(inputTable, targetTable Table) ([]columnOp, error) {
+	var columnOps []columnOp
 	var errors error
 
 	for _, inCol := range inputTable.Columns {
@@ -410,30 +792,97 @@ func checkColumnsWithoutOrdering(inputTable, targetTable Table) ([]string, error
 				foundMatching = true
 				if err := checkColumn(inCol, targetCol); err != nil {
 					errors = multierror.Append(errors, err)
+					continue
+				}
+				widenOps, err := widenColumn(targetTable, inCol, targetCol, true)
+				if err != nil {
+					errors = multierror.Append(errors, err)
 				}
+				columnOps = append(columnOps, widenOps...)
 			}
 		}
 		if !foundMatching {
 			log.Printf("Missing column -- running alter table\n")
 			alterSQL := fmt.Sprintf(`ALTER TABLE "%s"."%s" ADD COLUMN %s`,
 				targetTable.Meta.Schema, targetTable.Name, getColumnSQL(inCol))
-			columnOps = append(columnOps, alterSQL)
+			columnOps = append(columnOps, columnOp{sql: alterSQL})
 		}
 	}
 	return columnOps, errors
 }
 
+// noInPlaceEncodings are the encodings redshift won't ALTER COLUMN ... TYPE, see
+// https://docs.aws.amazon.com/redshift/latest/dg/r_ALTER_TABLE.html
+var noInPlaceEncodings = map[string]bool{"BYTEDICT": true, "RUNLENGTH": true, "TEXT255": true, "TEXT32K": true}
+
+// widenColumn returns the statements that widen the target column to the input column's type,
+// if it needs widening. Redshift can only widen varchars in place, and only if they have no
+// default, aren't a primary key and aren't in one of the noInPlaceEncodings. Anything else is copied into a new column of the wider type
+// which then replaces the original, moving it to the end of the table. canMove is whether that's ok.
+func widenColumn(targetTable Table, inCol, targetCol ColInfo, canMove bool) ([]columnOp, error) {
+	inType, inErr := parseType(inCol.Type)
+	targetType, targetErr := parseType(targetCol.Type)
+	if inErr != nil || targetErr != nil {
+		return nil, nil
+	}
+	change := compareTypes(inType, targetType)
+	if change == typeSame || change == typeNarrower || change == typeIncompatible {
+		return nil, nil
+	}
+
+	table := fmt.Sprintf(`"%s"."%s"`, targetTable.Meta.Schema, targetTable.Name)
+	if change == typeWidenInPlace && targetCol.DefaultVal == "" && !targetCol.PrimaryKey &&
+		!noInPlaceEncodings[strings.ToUpper(targetCol.Encoding)] {
+		log.Printf("Narrow column -- running alter table\n")
+		return []columnOp{{
+			sql:       fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN "%s" TYPE %s`, table, targetCol.Name, inType),
+			outsideTx: true,
+		}}, nil
+	}
+
+	reason := fmt.Sprintf("widening %s to %s", targetType, inType)
+	switch {
+	case !canMove:
+		return nil, rebuildError{targetCol.Name, reason + " would move it to the end of the table"}
+	case targetCol.SortOrdinal != 0 || targetCol.DistKey:
+		return nil, rebuildError{targetCol.Name, reason + " would drop a sort or dist key"}
+	case targetCol.PrimaryKey:
+		return nil, rebuildError{targetCol.Name, reason + " would drop a primary key"}
+	case targetCol.NotNull && targetCol.DefaultVal == "":
+		return nil, rebuildError{targetCol.Name, reason + " needs a default to add it as not null"}
+	}
+
+	log.Printf("Narrow column -- swapping for a wider one\n")
+	wide := inCol
+	wide.Name = targetCol.Name + "__widened"
+	return []columnOp{
+		{sql: fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, table, getColumnSQL(wide))},
+		{sql: fmt.Sprintf(`UPDATE %s SET "%s" = "%s"`, table, wide.Name, targetCol.Name)},
+		{sql: fmt.Sprintf(`ALTER TABLE %s DROP COLUMN "%s"`, table, targetCol.Name)},
+		{sql: fmt.Sprintf(`ALTER TABLE %s RENAME COLUMN "%s" TO "%s"`, table, wide.Name, targetCol.Name)},
+	}, nil
+}
+
 func checkColumn(inCol ColInfo, targetCol ColInfo) error {
 	var errors error
 	mismatchedTemplate := "mismatched column: %s property: %s, input: %v, target: %v"
 	if inCol.Name != targetCol.Name {
 		errors = multierror.Append(errors, fmt.Errorf(mismatchedTemplate, inCol.Name, "Name", inCol.Name, targetCol.Name))
 	}
-	if typeMapping[inCol.Type] != targetCol.Type {
-		if strings.HasPrefix(typeMapping[inCol.Type], "character varying") && strings.HasPrefix(typeMapping[inCol.Type], "character varying") {
-			// If they are both varchars but differing values, we will ignore this
-		} else {
-			errors = multierror.Append(errors, fmt.Errorf(mismatchedTemplate, inCol.Name, "Type", typeMapping[inCol.Type], targetCol.Type))
+	inType, inErr := parseType(inCol.Type)
+	targetType, targetErr := parseType(targetCol.Type)
+	if inErr != nil || targetErr != nil {
+		// one of them is something we don't know about, so the best we can do is compare them as is
+		if typeSQL(inCol.Type) != targetCol.Type {
+			errors = multierror.Append(errors, fmt.Errorf(mismatchedTemplate, inCol.Name, "Type", typeSQL(inCol.Type), targetCol.Type))
+		}
+	} else {
+		switch compareTypes(inType, targetType) {
+		case typeIncompatible:
+			// anything wider is taken care of by widenColumn
+			errors = multierror.Append(errors, fmt.Errorf(mismatchedTemplate, inCol.Name, "Type", inType, targetType))
+		case typeNarrower:
+			log.Printf("Input column %s is %s, narrower than the target's %s, which is left as is", inCol.Name, inType, targetType)
 		}
 	}
 	if inCol.DefaultVal != targetCol.DefaultVal {
@@ -455,11 +904,20 @@ func checkColumn(inCol ColInfo, targetCol ColInfo) error {
 	return errors
 }
 
-// Copy copies either CSV or JSON data present in an S3 file into a redshift table.
-// It also supports CSV or JSON data pointed at by a manifest file, if you pass in a manifest file.
+// Copy copies either CSV, JSON, parquet or orc data present in an S3 file into a redshift table.
+// It also supports data pointed at by a manifest file, if you pass in a manifest file.
 // this is meant to be run in a transaction, so the first arg must be a sql.Tx
-// if not using jsonPaths, set s3File.JSONPaths to "auto"
-func (r *Redshift) Copy(tx *sql.Tx, f s3filepath.S3File, delimiter string, creds, gzip bool) error {
+// JSON is loaded with 'auto' unless s3File.JSONPaths is set
+// opts are usually the table config's Meta.Copy
+func (r *Redshift) Copy(tx *sql.Tx, f s3filepath.S3File, opts CopyOptions, delimiter string, creds, gzip bool) error {
+	return r.copyInto(tx, fmt.Sprintf(`"%s"."%s"`, f.Schema, f.Table), f, opts, delimiter, creds, gzip)
+}
+
+// copyInto runs the COPY for f against the already quoted target table, which need not be f's table
+func (r *Redshift) copyInto(tx *sql.Tx, target string, f s3filepath.S3File, opts CopyOptions, delimiter string, creds, gzip bool) error {
+	if err := CheckCopyOptionsFor(opts, f.Format, delimiter); err != nil {
+		return err
+	}
 	var credSQL string
 	if creds {
 		credSQL = fmt.Sprintf(`IAM_ROLE '%s'`, f.Bucket.RedshiftRoleARN)
@@ -473,24 +931,171 @@ func (r *Redshift) Copy(tx *sql.Tx, f s3filepath.S3File, delimiter string, creds
 		manifestSQL = "manifest"
 	}
 
+	// columnar files bring their own compression and types, so GZIP, TIMEFORMAT and
+	// TRUNCATECOLUMNS are all rejected by redshift for them
+	var copySQL string
+	if f.IsColumnar() {
+		copySQL = fmt.Sprintf(`COPY %s FROM '%s' FORMAT AS %s REGION '%s' STATUPDATE ON %s %s`,
+			target, f.GetDataFilename(), strings.ToUpper(f.Format), f.Bucket.Region, manifestSQL, credSQL)
+	} else {
+		copySQL = rowCopySQL(target, f, opts, delimiter, gzipSQL, manifestSQL, credSQL)
+	}
+
+	if r.skip(copySQL, false) {
+		return nil
+	}
+
+	// stl_load_errors is keyed on the session, which we can't ask for after a failed COPY
+	// has aborted the transaction
+	var session int64
+	if err := tx.QueryRowContext(r.ctx, "SELECT pg_backend_pid()").Scan(&session); err != nil {
+		return fmt.Errorf("issue getting session id: %s", err)
+	}
+
+	log.Printf("Running command: %s", copySQL)
+	// can't use prepare b/c of redshift-specific syntax that postgres does not like
+	if _, err := tx.ExecContext(r.ctx, copySQL); err != nil {
+		return r.loadError(session, target, err)
+	}
+	return nil
+}
+
+// rowCopySQL builds the COPY statement for JSON or CSV input
+func rowCopySQL(target string, f s3filepath.S3File, opts CopyOptions, delimiter, gzipSQL, manifestSQL, credSQL string) string {
 	// default to CSV
 	jsonSQL := ""
 	jsonPathsSQL := ""
-	// always removequotes, UNLOAD should add quotes
-	// always say escape for CSVs, UNLOAD should always escape
-	delimSQL := fmt.Sprintf("DELIMITER AS '%s' REMOVEQUOTES ESCAPE TRIMBLANKS EMPTYASNULL ACCEPTANYDATE", delimiter)
+	delimSQL := fmt.Sprintf("DELIMITER AS '%s' %s", delimiter, opts.csvSQL())
 	// figure out if we're doing JSON - no delim means JSON
 	if delimiter == "" {
 		jsonSQL = "JSON"
 		jsonPathsSQL = "'auto'"
 		delimSQL = ""
+		// a JSONPaths file maps fields to columns in order, so name them
+		if f.JSONPaths != "" {
+			jsonPathsSQL = fmt.Sprintf("'%s'", f.JSONPaths)
+			var columns []string
+			for _, c := range f.Columns {
+				columns = append(columns, fmt.Sprintf(`"%s"`, c))
+			}
+			target = fmt.Sprintf("%s (%s)", target, strings.Join(columns, ", "))
+		}
 	}
-	copySQL := fmt.Sprintf(`COPY "%s"."%s" FROM '%s' WITH %s %s %s REGION '%s' TIMEFORMAT 'auto' TRUNCATECOLUMNS STATUPDATE ON %s %s %s`,
-		f.Schema, f.Table, f.GetDataFilename(), gzipSQL, jsonSQL, jsonPathsSQL, f.Bucket.Region, manifestSQL, credSQL, delimSQL)
-	log.Printf("Running command: %s", copySQL)
-	// can't use prepare b/c of redshift-specific syntax that postgres does not like
-	_, err := tx.ExecContext(r.ctx, copySQL)
-	return err
+	return fmt.Sprintf(`COPY %s FROM '%s' WITH %s %s %s REGION '%s' %s STATUPDATE ON %s %s %s %s`,
+		target, f.GetDataFilename(), gzipSQL, jsonSQL, jsonPathsSQL, f.Bucket.Region, opts.formatSQL(),
+		manifestSQL, credSQL, delimSQL, opts.extraSQL(delimiter))
+}
+
+// loadError looks up why redshift rejected the input of a failed COPY in the given session.
+// Errors that aren't about rejected input, or that we can't find details for, are returned as is.
+func (r *Redshift) loadError(session int64, target string, copyErr error) error {
+	if !strings.Contains(copyErr.Error(), "stl_load_errors") {
+		return copyErr
+	}
+
+	// the load transaction is aborted by now, so look outside of it
+	q := fmt.Sprintf(loadErrorsQueryFormat, session, maxLoadErrorRows)
+	rows, err := r.QueryContext(r.ctx, q)
+	if err != nil {
+		log.Printf("issue looking up load errors for session %d: %s", session, err)
+		return copyErr
+	}
+	defer rows.Close()
+
+	loadErr := LoadError{Target: target, Err: copyErr}
+	for rows.Next() {
+		var row LoadErrorRow
+		if err := rows.Scan(&loadErr.Query, &row.Filename, &row.LineNumber, &row.Column,
+			&row.RawFieldValue, &row.ErrCode, &row.ErrReason,
+		); err != nil {
+			log.Printf("issue scanning load errors for session %d: %s", session, err)
+			return copyErr
+		}
+		loadErr.Rows = append(loadErr.Rows, row)
+	}
+	if err := rows.Err(); err != nil || len(loadErr.Rows) == 0 {
+		log.Printf("no load errors found for session %d: %v", session, err)
+		return copyErr
+	}
+	return &loadErr
+}
+
+// Merge upserts the data in an S3 file into a redshift table, keyed on the table's primary key columns.
+// The file is copied into a temporary staging table shaped like the target, rows in the target that
+// match a staged row on every primary key column are deleted, and then the staged rows are inserted.
+// Input that repeats a primary key is an error, since there's no telling which of its rows is current.
+// this is meant to be run in a transaction, so the first arg must be a sql.Tx
+func (r *Redshift) Merge(tx *sql.Tx, f s3filepath.S3File, table Table, delimiter string, creds, gzip bool) error {
+	var keyConds, keys []string
+	for _, c := range table.Columns {
+		if c.PrimaryKey {
+			keyConds = append(keyConds, fmt.Sprintf(`"%s"."%s"."%s" = "%s"."%s"`,
+				table.Meta.Schema, table.Name, c.Name, mergeStagingTable(table), c.Name))
+			keys = append(keys, fmt.Sprintf(`"%s"`, c.Name))
+		}
+	}
+	if len(keyConds) == 0 {
+		return fmt.Errorf("merge into %s.%s requires at least one primary key column", table.Meta.Schema, table.Name)
+	}
+
+	target := fmt.Sprintf(`"%s"."%s"`, table.Meta.Schema, table.Name)
+	staging := fmt.Sprintf(`"%s"`, mergeStagingTable(table))
+
+	// temp tables can't be put in a schema, and LIKE keeps the target's column order, dist and sort keys
+	createSQL := fmt.Sprintf(`CREATE TEMP TABLE %s (LIKE %s)`, staging, target)
+	if !r.skip(createSQL, false) {
+		log.Printf("Running command: %s", createSQL)
+		if _, err := tx.ExecContext(r.ctx, createSQL); err != nil {
+			return fmt.Errorf("issue creating staging table: %s", err)
+		}
+	}
+
+	if err := r.copyInto(tx, staging, f, table.Meta.Copy, delimiter, creds, gzip); err != nil {
+		return err
+	}
+
+	// redshift doesn't enforce primary keys, so each of a repeated key's rows would be inserted
+	keyList := strings.Join(keys, ", ")
+	dupSQL := fmt.Sprintf(`SELECT %s FROM %s GROUP BY %s HAVING COUNT(*) > 1 LIMIT 1`, keyList, staging, keyList)
+	if !r.skip(dupSQL, false) {
+		log.Printf("Running command: %s", dupSQL)
+		dup := make([]sql.NullString, len(keys))
+		dest := make([]interface{}, len(keys))
+		for i := range dup {
+			dest[i] = &dup[i]
+		}
+		err := tx.QueryRowContext(r.ctx, dupSQL).Scan(dest...)
+		if err == nil {
+			values := make([]string, len(dup))
+			for i, v := range dup {
+				values[i] = v.String
+			}
+			return fmt.Errorf("input for %s repeats primary key (%s) = (%s), so it can't be merged",
+				target, keyList, strings.Join(values, ", "))
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("issue checking for repeated primary keys: %s", err)
+		}
+	}
+
+	for _, q := range []string{
+		fmt.Sprintf(`DELETE FROM %s USING %s WHERE %s`, target, staging, strings.Join(keyConds, " AND ")),
+		fmt.Sprintf(`INSERT INTO %s SELECT * FROM %s`, target, staging),
+		// the staging table would go away with the session anyway, but connections get reused
+		fmt.Sprintf(`DROP TABLE %s`, staging),
+	} {
+		if r.skip(q, false) {
+			continue
+		}
+		log.Printf("Running command: %s", q)
+		if _, err := tx.ExecContext(r.ctx, q); err != nil {
+			return fmt.Errorf("issue running statement %s: %s", q, err)
+		}
+	}
+	return nil
+}
+
+func mergeStagingTable(table Table) string {
+	return fmt.Sprintf("%s_%s_staging", table.Meta.Schema, table.Name)
 }
 
 // UpdateLatencyInfo updates the latency table with the current time to indicate
@@ -500,12 +1105,20 @@ func (r *Redshift) UpdateLatencyInfo(tx *sql.Tx, table Table) error {
 
 	// Insert a row for the latencies table if it doesn't already exist.
 	// We do this outside of the transaction, since there's no reason to lock the entire table.
-	_, err := r.ExecContext(r.ctx, fmt.Sprintf(
+	insertSQL := fmt.Sprintf(
 		`INSERT INTO latencies (name) (
 				SELECT '%s' AS name
 			EXCEPT
 				SELECT name FROM latencies WHERE name = '%s'
-		)`, dest, dest))
+		)`, dest, dest)
+	updateSQL := fmt.Sprintf("UPDATE latencies SET last_update = current_timestamp WHERE name = '%s'", dest)
+	if r.dryRun {
+		// there may not be a row to read the last update from, so only record what would be run
+		r.skip(insertSQL, true)
+		r.skip(updateSQL, true)
+		return nil
+	}
+	_, err := r.ExecContext(r.ctx, insertSQL)
 
 	// Get the last latency value out of the table, for logging.
 	// We should do it in the same place as the insert, otherwise there's a chance serialization ends up without it existing yet.
@@ -521,9 +1134,7 @@ func (r *Redshift) UpdateLatencyInfo(tx *sql.Tx, table Table) error {
 	// This one should be inside the transaction!
 	// TODO: 8/27/2020 Redshift is having some issues with serialization right now (see ticket 7320802091)
 	// so we're going to leave this outside, so it at least updates, if not to the "best" time.
-	_, err = r.ExecContext(r.ctx, fmt.Sprintf(
-		"UPDATE latencies SET last_update = current_timestamp WHERE name = '%s'",
-		dest))
+	_, err = r.ExecContext(r.ctx, updateSQL)
 	if err != nil {
 		return fmt.Errorf("error saving new latency to table for %s: %s", dest, err)
 	}
@@ -537,35 +1148,46 @@ func (r *Redshift) UpdateLatencyInfo(tx *sql.Tx, table Table) error {
 	return nil
 }
 
-// Truncate deletes all items from a table, given a transaction, a schema string and a table name
+// Truncate deletes all items from a table, given a transaction, a schema string and a table name,
+// and returns how many were deleted
 // you should run vacuum and analyze soon after doing this for performance reasons
-func (r *Redshift) Truncate(tx *sql.Tx, schema, table string) error {
+func (r *Redshift) Truncate(tx *sql.Tx, schema, table string) (int64, error) {
 	// We run 'DELETE FROM' instead of 'TRUNCATE' because 'TRUNCATE' can't be run in a transaction.
 	// See http://docs.aws.amazon.com/redshift/latest/dg/r_TRUNCATE.html.
-	truncStmt, err := tx.PrepareContext(r.ctx, fmt.Sprintf(`DELETE FROM "%s"."%s"`, schema, table))
-	if err != nil {
-		return err
+	truncSQL := fmt.Sprintf(`DELETE FROM "%s"."%s"`, schema, table)
+	if r.skip(truncSQL, false) {
+		return 0, nil
 	}
-	_, err = truncStmt.ExecContext(r.ctx)
-	return err
+	return r.deleteRows(tx, truncSQL)
 }
 
 // TruncateInTimeRange deletes all items within a specific time range - that is,
 // matching `dataDate` when rounded to a certain granularity `timeGranularity`
+// and returns how many were deleted
 // NOTE: this assumes that "time" is a column in the table
 func (r *Redshift) TruncateInTimeRange(tx *sql.Tx, schema, table, dataDateCol string,
-	start, end time.Time) error {
+	start, end time.Time) (int64, error) {
 	truncSQL := fmt.Sprintf(`
 		DELETE FROM "%s"."%s"
 		WHERE "%s" >= '%s' AND "%s" < '%s'
 		`, schema, table, dataDateCol, start.Format("2006-01-02 15:04:05"),
 		dataDateCol, end.Format("2006-01-02 15:04:05"))
-	truncStmt, err := tx.PrepareContext(r.ctx, truncSQL)
-	if err != nil {
-		return err
+	if r.skip(truncSQL, false) {
+		return 0, nil
 	}
-
 	log.Printf("Refreshing with the latest data. Running command: %s", truncSQL)
-	_, err = truncStmt.ExecContext(r.ctx)
-	return err
+	return r.deleteRows(tx, truncSQL)
+}
+
+// deleteRows runs a DELETE in the transaction and returns how many rows it deleted
+func (r *Redshift) deleteRows(tx *sql.Tx, deleteSQL string) (int64, error) {
+	deleteStmt, err := tx.PrepareContext(r.ctx, deleteSQL)
+	if err != nil {
+		return 0, err
+	}
+	result, err := deleteStmt.ExecContext(r.ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
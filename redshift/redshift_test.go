@@ -3,6 +3,7 @@ package redshift
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -23,6 +24,13 @@ var (
 	textCtx = context.Background()
 )
 
+// every COPY first looks up its session, in case it needs to find its load errors
+func expectSession(mock sqlmock.Sqlmock) {
+	sessionRows := sqlmock.NewRows([]string{"pg_backend_pid"})
+	sessionRows.AddRow(1234)
+	mock.ExpectQuery(`SELECT pg_backend_pid\(\)`).WithArgs().WillReturnRows(sessionRows)
+}
+
 // helper for TestTableFromConf - marshals the table into a file
 func getTempConfFromTable(configKey, name string, table Table) (string, error) {
 	table.Name = name
@@ -108,6 +116,50 @@ func TestTableFromConf(t *testing.T) {
 	if assert.Error(t, err) {
 		assert.Equal(t, true, strings.Contains(err.Error(), "data date column must be set"))
 	}
+
+	// one with an unknown column type
+	badType := matchingTable
+	badType.Columns = []ColInfo{{Name: "id", Type: "varchar(1024)"}, {Name: "amount", Type: "money"}}
+	fileName, err = getTempConfFromTable(configKey, table, badType)
+	assert.NoError(t, err)
+	f.ConfFile = fileName
+	returnedTable, err = db.GetTableFromConf(f)
+	if assert.Error(t, err) {
+		assert.Equal(t, true, strings.Contains(err.Error(), `column amount: unsupported type "money"`))
+	}
+
+	// one with an unknown mode
+	badMode := matchingTable
+	badMode.Meta.Mode = "replace"
+	fileName, err = getTempConfFromTable(configKey, table, badMode)
+	assert.NoError(t, err)
+	f.ConfFile = fileName
+	returnedTable, err = db.GetTableFromConf(f)
+	if assert.Error(t, err) {
+		assert.Equal(t, true, strings.Contains(err.Error(), "unsupported mode"))
+	}
+
+	// one with conflicting copy options
+	badCopy := matchingTable
+	badCopy.Meta.Copy = CopyOptions{Quote: "%"}
+	fileName, err = getTempConfFromTable(configKey, table, badCopy)
+	assert.NoError(t, err)
+	f.ConfFile = fileName
+	returnedTable, err = db.GetTableFromConf(f)
+	if assert.Error(t, err) {
+		assert.Equal(t, true, strings.Contains(err.Error(), "copy option quote requires csv"))
+	}
+
+	// one with a source that isn't a JSONPath expression
+	badSource := matchingTable
+	badSource.Columns = []ColInfo{{Name: "id", Type: "varchar(1024)", Source: "user.id"}}
+	fileName, err = getTempConfFromTable(configKey, table, badSource)
+	assert.NoError(t, err)
+	f.ConfFile = fileName
+	returnedTable, err = db.GetTableFromConf(f)
+	if assert.Error(t, err) {
+		assert.Equal(t, true, strings.Contains(err.Error(), "column id: source user.id must be a JSONPath expression"))
+	}
 }
 
 // I'm not going to worry about if the db throws an error
@@ -127,10 +179,12 @@ func TestGetTableMetadata(t *testing.T) {
 			PrimaryKey:  false,
 			DistKey:     false,
 			SortOrdinal: 0,
+			Encoding:    "lzo",
 		}},
 		Meta: Meta{
 			Schema:         schema,
 			DataDateColumn: dataDateCol,
+			DistStyle:      DistEven,
 		},
 	}
 
@@ -155,10 +209,15 @@ func TestGetTableMetadata(t *testing.T) {
 	// don't look for the whole query, just the important bits
 	colInfoRegex := fmt.Sprintf(`SELECT .*nspname = '%s' .*relname = '%s'.*`, schema, table)
 	colInfoRows := sqlmock.NewRows([]string{"name", "col_type", "default_val",
-		"not_null", "primary_key", "dist_key", "sort_ord"})
+		"not_null", "primary_key", "dist_key", "sort_ord", "encoding"})
 	// matches expectedTable above, used for returning from sql mock
-	colInfoRows.AddRow("foo", "integer", 5, false, false, false, 0)
+	colInfoRows.AddRow("foo", "integer", 5, false, false, false, 0, "lzo")
 	mock.ExpectQuery(colInfoRegex).WithArgs().WillReturnRows(colInfoRows)
+	// dist style
+	distStyleRegex := fmt.Sprintf(`SELECT c.reldiststyle .*nspname = '%s' AND c.relname = '%s'`, schema, table)
+	distStyleRows := sqlmock.NewRows([]string{"reldiststyle"})
+	distStyleRows.AddRow(0)
+	mock.ExpectQuery(distStyleRegex).WithArgs().WillReturnRows(distStyleRows)
 	// last data
 	// This is a regex, so we have to escape parentheses.
 	dateRegex := fmt.Sprintf(`SELECT MAX\("%s"\) FROM "%s"."%s" WHERE "%s" > GETDATE\(\) - INTERVAL '1 %s'`, dataDateCol, schema, table, dataDateCol, "DAY")
@@ -202,6 +261,81 @@ func TestGetTableMetadata(t *testing.T) {
 	}
 }
 
+// empty tables aren't in svv_table_info, so interleaved sort keys are read from their ordinals' signs
+func TestGetTableMetadataInterleaved(t *testing.T) {
+	schema, table, dataDateCol := "testschema", "testtable", "time"
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	existRows := sqlmock.NewRows([]string{"table_name"})
+	existRows.AddRow(table)
+	mock.ExpectQuery(`SELECT table_name FROM information_schema.tables`).WithArgs().WillReturnRows(existRows)
+	colInfoRows := sqlmock.NewRows([]string{"name", "col_type", "default_val",
+		"not_null", "primary_key", "dist_key", "sort_ord", "encoding"})
+	colInfoRows.AddRow("time", "timestamp without time zone", "", false, false, false, -1, "lzo")
+	colInfoRows.AddRow("id", "integer", "", false, false, false, -2, "lzo")
+	mock.ExpectQuery(`SELECT .*nspname = 'testschema' .*relname = 'testtable'.*`).WithArgs().WillReturnRows(colInfoRows)
+	distStyleRows := sqlmock.NewRows([]string{"reldiststyle"})
+	distStyleRows.AddRow(8)
+	mock.ExpectQuery(`SELECT c.reldiststyle`).WithArgs().WillReturnRows(distStyleRows)
+	mock.ExpectQuery(`SELECT sortkey1 FROM svv_table_info WHERE "schema" = 'testschema' AND "table" = 'testtable'`).
+		WithArgs().WillReturnError(sql.ErrNoRows)
+	dateRows := sqlmock.NewRows([]string{"date"})
+	dateRows.AddRow(time.Now())
+	mock.ExpectQuery(`SELECT MAX\("time"\)`).WithArgs().WillReturnRows(dateRows)
+
+	returnedTable, _, err := mockRedshift.GetTableMetadata(schema, table, dataDateCol)
+	assert.NoError(t, err)
+	assert.Equal(t, SortInterleaved, returnedTable.Meta.SortStyle)
+	assert.Equal(t, DistAll, returnedTable.Meta.DistStyle)
+	assert.Equal(t, []string{"time", "id"}, sortColumns(*returnedTable))
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+// a single interleaved sort column has a positive ordinal, so its style comes from svv_table_info
+func TestGetTableMetadataSingleInterleaved(t *testing.T) {
+	schema, table := "testschema", "testtable"
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	for _, tc := range []struct {
+		sortKey  string
+		expected string
+	}{
+		{"INTERLEAVED", SortInterleaved},
+		{"time", SortCompound},
+	} {
+		existRows := sqlmock.NewRows([]string{"table_name"}).AddRow(table)
+		mock.ExpectQuery(`SELECT table_name FROM information_schema.tables`).WillReturnRows(existRows)
+		colInfoRows := sqlmock.NewRows([]string{"name", "col_type", "default_val",
+			"not_null", "primary_key", "dist_key", "sort_ord", "encoding"})
+		colInfoRows.AddRow("time", "timestamp without time zone", "", false, false, false, 1, "lzo")
+		colInfoRows.AddRow("id", "integer", "", false, false, false, 0, "lzo")
+		mock.ExpectQuery(`SELECT .*nspname = 'testschema' .*relname = 'testtable'.*`).WillReturnRows(colInfoRows)
+		mock.ExpectQuery(`SELECT c.reldiststyle`).WillReturnRows(sqlmock.NewRows([]string{"reldiststyle"}).AddRow(8))
+		mock.ExpectQuery(`SELECT sortkey1 FROM svv_table_info`).
+			WillReturnRows(sqlmock.NewRows([]string{"sortkey1"}).AddRow(tc.sortKey))
+
+		returnedTable, err := mockRedshift.getTable(schema, table)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, returnedTable.Meta.SortStyle, tc.sortKey)
+		assert.Equal(t, []string{"time"}, sortColumns(*returnedTable))
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
 // test create and getColumnSQL at the same time
 // getColumnSQL is too simple to be worth testing independently
 func TestCreateTable(t *testing.T) {
@@ -209,10 +343,10 @@ func TestCreateTable(t *testing.T) {
 	dbTable := Table{
 		Name: table,
 		Columns: []ColInfo{
-			{"test1", "int", "100", true, false, true, 1},
-			{"id", "text", "", false, true, false, 0},
-			{"somelongtext", "longtext", "", false, false, false, 0},
-			{"test2", "bigint", "9999999999", false, false, false, 0},
+			{"test1", "int", "100", true, false, true, 1, "", ""},
+			{"id", "text", "", false, true, false, 0, "", ""},
+			{"somelongtext", "longtext", "", false, false, false, 0, "", ""},
+			{"test2", "bigint", "9999999999", false, false, false, 0, "", ""},
 		},
 		Meta: Meta{Schema: schema},
 	}
@@ -220,10 +354,11 @@ func TestCreateTable(t *testing.T) {
 	//createSQL := `aasdadsa character varying(256) PRIMARY KEY , test5 integer DEFAULT 100 NOT NULL SORTKEY DISTKEY , someww221longtext character varying(10000), test2 bigint DEFAULT 9999999999`
 	//sql := fmt.Sprintf(`CREATE TABLE "%s"."%s" (%s)`, schema, table, createSQL)
 	regex := `CREATE TABLE ".*".".*".*` +
-		`"test1" integer DEFAULT 100 NOT NULL SORTKEY.*` +
-		`DISTKEY.*"id" character varying\(256\).*PRIMARY KEY.*` +
+		`"test1" integer DEFAULT 100 NOT NULL.*` +
+		`"id" character varying\(256\).*PRIMARY KEY.*` +
 		`"somelongtext" character varying\(65535\).*` + // a little awk, but the prepare makes sure this is good
-		`"test2" bigint DEFAULT 9999999999.*`
+		`"test2" bigint DEFAULT 9999999999.*\) ` +
+		`DISTSTYLE KEY DISTKEY\("test1"\) COMPOUND SORTKEY\("test1"\)$`
 
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
@@ -251,9 +386,9 @@ func TestNoKeyCreateTable(t *testing.T) {
 	dbTable := Table{
 		Name: table,
 		Columns: []ColInfo{
-			{"test1", "int", "100", true, false, false, 0},
-			{"id", "text", "", false, false, false, 0},
-			{"somelongtext", "longtext", "", false, false, false, 0},
+			{"test1", "int", "100", true, false, false, 0, "", ""},
+			{"id", "text", "", false, false, false, 0, "", ""},
+			{"somelongtext", "longtext", "", false, false, false, 0, "", ""},
 		},
 		Meta: Meta{Schema: schema},
 	}
@@ -277,6 +412,113 @@ func TestNoKeyCreateTable(t *testing.T) {
 	}
 }
 
+func TestTableAttributesSQL(t *testing.T) {
+	tests := []struct {
+		meta    Meta
+		columns []ColInfo
+		sql     string
+		errText string
+	}{
+		{
+			// keys default to a compound sort key, and key distribution
+			columns: []ColInfo{
+				{Name: "b", SortOrdinal: 2},
+				{Name: "a", SortOrdinal: 1, DistKey: true},
+				{Name: "c"},
+			},
+			sql: `DISTSTYLE KEY DISTKEY("a") COMPOUND SORTKEY("a","b")`,
+		},
+		{
+			meta: Meta{SortStyle: SortInterleaved, DistStyle: DistAll},
+			columns: []ColInfo{
+				{Name: "a", SortOrdinal: 1},
+				{Name: "b", SortOrdinal: 2},
+			},
+			sql: `DISTSTYLE ALL INTERLEAVED SORTKEY("a","b")`,
+		},
+		{
+			meta:    Meta{DistStyle: DistAuto},
+			columns: []ColInfo{{Name: "a"}},
+			sql:     `DISTSTYLE AUTO`,
+		},
+		{
+			columns: []ColInfo{{Name: "a"}},
+			sql:     ``,
+		},
+		{
+			meta:    Meta{DistStyle: DistEven},
+			columns: []ColInfo{{Name: "a", DistKey: true}},
+			errText: "diststyle even can't have a distkey column",
+		},
+		{
+			meta:    Meta{DistStyle: DistKey},
+			columns: []ColInfo{{Name: "a"}},
+			errText: "diststyle key requires a distkey column",
+		},
+		{
+			columns: []ColInfo{{Name: "a", DistKey: true}, {Name: "b", DistKey: true}},
+			errText: "only one distkey column is allowed",
+		},
+		{
+			meta:    Meta{SortStyle: SortInterleaved},
+			columns: []ColInfo{{Name: "a"}},
+			errText: "sortstyle interleaved requires at least one column",
+		},
+		{
+			meta:    Meta{SortStyle: "random"},
+			columns: []ColInfo{{Name: "a", SortOrdinal: 1}},
+			errText: "unsupported sortstyle",
+		},
+		{
+			meta:    Meta{DistStyle: "random"},
+			columns: []ColInfo{{Name: "a"}},
+			errText: "unsupported diststyle",
+		},
+	}
+	for _, test := range tests {
+		sql, err := tableAttributesSQL(Table{Columns: test.columns, Meta: test.meta})
+		if test.errText != "" {
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.errText)
+			}
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.sql, sql)
+	}
+}
+
+// that a diststyle alone is enough to create a table
+func TestDistStyleCreateTable(t *testing.T) {
+	dbTable := Table{
+		Name: "tablename",
+		Columns: []ColInfo{
+			{"test1", "int", "", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: "testschema", DistStyle: DistEven},
+	}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("This needs to be here, but not evaluated")
+	mock.ExpectExec(`CREATE TABLE "testschema"."tablename" \( "test1" integer\s*\) DISTSTYLE EVEN$`).
+		WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.CreateTable(tx, dbTable))
+	assert.NoError(t, tx.Commit())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
 func TestJSONCopy(t *testing.T) {
 	schema, table := "testschema", "tablename"
 	bucket, region, redshiftRoleARN := "bucket", "region", "redshiftRoleARN"
@@ -302,12 +544,13 @@ func TestJSONCopy(t *testing.T) {
 	defer db.Close()
 	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
 	mock.ExpectBegin()
+	expectSession(mock)
 	mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	tx, err := mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.Copy(tx, s3File, "", true, true))
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "", true, true))
 	assert.NoError(t, tx.Commit())
 
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -324,12 +567,47 @@ func TestJSONCopy(t *testing.T) {
 	mockRedshift = Redshift{dbExecCloser: db, ctx: textCtx}
 
 	mock.ExpectBegin()
+	expectSession(mock)
 	mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	tx, err = mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.Copy(tx, s3File, "", false, false))
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "", false, false))
+	assert.NoError(t, tx.Commit())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestJSONPathsCopy(t *testing.T) {
+	b := s3filepath.S3Bucket{Name: "bucket", Region: "region", RedshiftRoleARN: "redshiftRoleARN"}
+	s3File := s3filepath.S3File{
+		Bucket:    b,
+		Schema:    "testschema",
+		Table:     "tablename",
+		Suffix:    "json.gz",
+		DataDate:  time.Now(),
+		JSONPaths: "s3://bucket/_staging/jsonpaths/testschema/tablename/x.json",
+		Columns:   []string{"id", "user_id"},
+	}
+	// the columns are named, since the JSONPaths file maps to them in order
+	execRegex := regexp.QuoteMeta(fmt.Sprintf(`COPY "testschema"."tablename" ("id", "user_id") FROM '%s' WITH GZIP JSON '%s' REGION 'region'`,
+		s3File.GetDataFilename(), s3File.JSONPaths))
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+	mock.ExpectBegin()
+	expectSession(mock)
+	mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "", true, true))
 	assert.NoError(t, tx.Commit())
 
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -363,12 +641,13 @@ func TestJSONManifestCopy(t *testing.T) {
 	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
 
 	mock.ExpectBegin()
+	expectSession(mock)
 	mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	tx, err := mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.Copy(tx, s3File, "", true, true))
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "", true, true))
 	assert.NoError(t, tx.Commit())
 
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -376,6 +655,226 @@ func TestJSONManifestCopy(t *testing.T) {
 	}
 }
 
+func TestColumnarCopy(t *testing.T) {
+	schema, table := "testschema", "tablename"
+	bucket, region, redshiftRoleARN := "bucket", "region", "redshiftRoleARN"
+	b := s3filepath.S3Bucket{
+		Name:            bucket,
+		Region:          region,
+		RedshiftRoleARN: redshiftRoleARN}
+
+	for _, test := range []struct {
+		suffix, format, sql string
+	}{
+		{"parquet", s3filepath.FormatParquet, `COPY "%s"."%s" FROM '%s' FORMAT AS PARQUET REGION '%s' STATUPDATE ON IAM_ROLE '%s'$`},
+		{"orc", s3filepath.FormatORC, `COPY "%s"."%s" FROM '%s' FORMAT AS ORC REGION '%s' STATUPDATE ON IAM_ROLE '%s'$`},
+		{"manifest", s3filepath.FormatParquet, `COPY "%s"."%s" FROM '%s' FORMAT AS PARQUET REGION '%s' STATUPDATE ON manifest IAM_ROLE '%s'$`},
+	} {
+		s3File := s3filepath.S3File{
+			Bucket:   b,
+			Schema:   schema,
+			Table:    table,
+			Suffix:   test.suffix,
+			DataDate: time.Now(),
+			Format:   test.format,
+		}
+		// GZIP and the delimiter should both be ignored
+		execRegex := fmt.Sprintf(test.sql, schema, table, s3File.GetDataFilename(), region, redshiftRoleARN)
+
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+		mock.ExpectBegin()
+		expectSession(mock)
+		mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		tx, err := mockRedshift.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "|", true, true))
+		assert.NoError(t, tx.Commit())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expections: %s", err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	schema, table := "testschema", "tablename"
+	bucket, region, redshiftRoleARN := "bucket", "region", "redshiftRoleARN"
+	b := s3filepath.S3Bucket{
+		Name:            bucket,
+		Region:          region,
+		RedshiftRoleARN: redshiftRoleARN}
+	s3File := s3filepath.S3File{
+		Bucket:   b,
+		Schema:   schema,
+		Table:    table,
+		Suffix:   "json.gz",
+		DataDate: time.Now(),
+	}
+	dbTable := Table{
+		Name: table,
+		Columns: []ColInfo{
+			{"id", "text", "", true, true, false, 0, "", ""},
+			{"org", "text", "", true, true, false, 0, "", ""},
+			{"value", "int", "", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: schema},
+	}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TEMP TABLE "testschema_tablename_staging" \(LIKE "testschema"."tablename"\)`).
+		WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSession(mock)
+	mock.ExpectExec(fmt.Sprintf(`COPY "testschema_tablename_staging" FROM '%s' WITH GZIP JSON 'auto' REGION '%s'`, s3File.GetDataFilename(), region)).
+		WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT "id", "org" FROM "testschema_tablename_staging" GROUP BY "id", "org" HAVING COUNT\(\*\) > 1 LIMIT 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org"}))
+	mock.ExpectExec(`DELETE FROM "testschema"."tablename" USING "testschema_tablename_staging" ` +
+		`WHERE "testschema"."tablename"."id" = "testschema_tablename_staging"."id" ` +
+		`AND "testschema"."tablename"."org" = "testschema_tablename_staging"."org"`).
+		WithArgs().WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO "testschema"."tablename" SELECT \* FROM "testschema_tablename_staging"`).
+		WithArgs().WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(`DROP TABLE "testschema_tablename_staging"`).
+		WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.Merge(tx, s3File, dbTable, "", true, true))
+	assert.NoError(t, tx.Commit())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	// input repeating a key fails before anything is merged
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TEMP TABLE "testschema_tablename_staging"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSession(mock)
+	mock.ExpectExec(`COPY "testschema_tablename_staging"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT "id", "org" FROM "testschema_tablename_staging" GROUP BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org"}).AddRow("1", "clever"))
+	mock.ExpectRollback()
+
+	tx, err = mockRedshift.Begin()
+	assert.NoError(t, err)
+	err = mockRedshift.Merge(tx, s3File, dbTable, "", true, true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `repeats primary key ("id", "org") = (1, clever)`)
+	}
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// that we disallow merging without a primary key to merge on
+func TestNoKeyMerge(t *testing.T) {
+	dbTable := Table{
+		Name: "tablename",
+		Columns: []ColInfo{
+			{"id", "text", "", true, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: "testschema"},
+	}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	mergeErr := mockRedshift.Merge(tx, s3filepath.S3File{}, dbTable, "", true, true)
+	if assert.Error(t, mergeErr) {
+		assert.Contains(t, mergeErr.Error(), "requires at least one primary key column")
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestCopyLoadErrors(t *testing.T) {
+	schema, table := "testschema", "tablename"
+	s3File := s3filepath.S3File{
+		Bucket:   s3filepath.S3Bucket{Name: "bucket", Region: "region", RedshiftRoleARN: "redshiftRoleARN"},
+		Schema:   schema,
+		Table:    table,
+		Suffix:   "json.gz",
+		DataDate: time.Now(),
+	}
+	copyErr := errors.New("pq: Load into table 'tablename' failed.  Check 'stl_load_errors' system table for details.")
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+	expectSession(mock)
+	mock.ExpectExec(`COPY "testschema"."tablename"`).WithArgs().WillReturnError(copyErr)
+	loadErrorRows := sqlmock.NewRows([]string{"query", "filename", "line_number", "colname",
+		"raw_field_value", "err_code", "err_reason"})
+	loadErrorRows.AddRow(42, "s3://bucket/part-0.json.gz", 7, "age", "abc", 1207, "Invalid digit, Value 'a', Pos 0, Type: Integer")
+	loadErrorRows.AddRow(42, "s3://bucket/part-0.json.gz", 9, "age", "1e", 1207, "Invalid digit, Value 'e', Pos 1, Type: Integer")
+	mock.ExpectQuery(`SELECT .* FROM stl_load_errors e .*LEFT JOIN stl_loaderror_detail d .*WHERE session = 1234\)`).
+		WithArgs().WillReturnRows(loadErrorRows)
+	mock.ExpectRollback()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	err = mockRedshift.Copy(tx, s3File, CopyOptions{}, "", true, true)
+	assert.NoError(t, tx.Rollback())
+
+	loadErr, ok := err.(*LoadError)
+	if assert.True(t, ok, "expected a LoadError, got %v", err) {
+		assert.Equal(t, int64(42), loadErr.Query)
+		assert.Equal(t, copyErr, loadErr.Err)
+		assert.Equal(t, []LoadErrorRow{
+			{"s3://bucket/part-0.json.gz", 7, "age", "abc", 1207, "Invalid digit, Value 'a', Pos 0, Type: Integer"},
+			{"s3://bucket/part-0.json.gz", 9, "age", "1e", 1207, "Invalid digit, Value 'e', Pos 1, Type: Integer"},
+		}, loadErr.Rows)
+		assert.Contains(t, loadErr.Error(), `s3://bucket/part-0.json.gz line 7 column age value "abc"`)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	// other errors are passed along untouched
+	db, mock, err = sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift = Redshift{dbExecCloser: db, ctx: textCtx}
+
+	mock.ExpectBegin()
+	expectSession(mock)
+	otherErr := errors.New("pq: S3ServiceException:Access Denied")
+	mock.ExpectExec(`COPY "testschema"."tablename"`).WithArgs().WillReturnError(otherErr)
+	mock.ExpectRollback()
+
+	tx, err = mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.Equal(t, otherErr, mockRedshift.Copy(tx, s3File, CopyOptions{}, "", true, true))
+	assert.NoError(t, tx.Rollback())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
 func TestTruncate(t *testing.T) {
 	schema, table := "test_schema", "test_table"
 	db, mock, err := sqlmock.New()
@@ -385,12 +884,14 @@ func TestTruncate(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectPrepare(fmt.Sprintf(`DELETE FROM "%s"."%s"`, schema, table))
-	mock.ExpectExec(`DELETE FROM ".*".".*"`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM ".*".".*"`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 7))
 	mock.ExpectCommit()
 
 	tx, err := mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.Truncate(tx, schema, table))
+	deleted, err := mockRedshift.Truncate(tx, schema, table)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), deleted)
 	assert.NoError(t, tx.Commit())
 
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -424,12 +925,13 @@ func TestCSVCopy(t *testing.T) {
 	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
 
 	mock.ExpectBegin()
+	expectSession(mock)
 	mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	tx, err := mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.Copy(tx, s3File, "|", true, true))
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "|", true, true))
 	assert.NoError(t, tx.Commit())
 
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -446,12 +948,13 @@ func TestCSVCopy(t *testing.T) {
 	mockRedshift = Redshift{dbExecCloser: db, ctx: textCtx}
 
 	mock.ExpectBegin()
+	expectSession(mock)
 	mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	tx, err = mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.Copy(tx, s3File, "|", false, false))
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "|", false, false))
 	assert.NoError(t, tx.Commit())
 
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -485,12 +988,13 @@ func TestCSVManifestCopy(t *testing.T) {
 	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
 
 	mock.ExpectBegin()
+	expectSession(mock)
 	mock.ExpectExec(execRegex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	tx, err := mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.Copy(tx, s3File, "|", true, true))
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "|", true, true))
 	assert.NoError(t, tx.Commit())
 
 	if err = mock.ExpectationsWereMet(); err != nil {
@@ -505,11 +1009,11 @@ func TestUpdateTable(t *testing.T) {
 		Name: table,
 		// order incorrectly on purpose to ensure ordering works
 		Columns: []ColInfo{
-			{"test3", "boolean", "true", false, false, false, 0},
-			{"test2", "int", "100", true, false, true, 1},
-			{"id", "text", "", false, true, false, 0},
-			{"test4", "float", "false", false, false, false, 0},
-			{"test5", "bigint", "9999999999", false, false, false, 0},
+			{"test3", "boolean", "true", false, false, false, 0, "", ""},
+			{"test2", "int", "100", true, false, true, 1, "", ""},
+			{"id", "text", "", false, true, false, 0, "", ""},
+			{"test4", "float", "false", false, false, false, 0, "", ""},
+			{"test5", "bigint", "9999999999", false, false, false, 0, "", ""},
 		},
 		Meta: Meta{Schema: schema},
 	}
@@ -537,14 +1041,66 @@ func TestUpdateTable(t *testing.T) {
 	fewerColumnsTargetTable := Table{
 		Name: table,
 		Columns: []ColInfo{
-			{"test3", "boolean", "true", false, false, false, 0},
+			{"test3", "boolean", "true", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: schema},
+	}
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.UpdateTable(tx, s3filepath.S3File{}, inputTable, fewerColumnsTargetTable))
+	assert.NoError(t, tx.Commit())
+}
+
+func TestUpdateTableWidening(t *testing.T) {
+	schema, table := "testschema", "tablename"
+
+	inputTable := Table{
+		Name: table,
+		Columns: []ColInfo{
+			{"id", "varchar(512)", "", false, false, false, 0, "", ""},
+			{"count", "bigint", "", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: schema},
+	}
+	targetTable := Table{
+		Name: table,
+		Columns: []ColInfo{
+			{"id", "character varying(256)", "", false, false, false, 0, "", ""},
+			{"count", "integer", "", false, false, false, 0, "", ""},
 		},
 		Meta: Meta{Schema: schema},
 	}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+
+	// varchars are widened outside of the transaction, before it starts
+	mock.ExpectExec(`ALTER TABLE "testschema"."tablename" ALTER COLUMN "id" TYPE character varying\(512\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	for _, regex := range []string{
+		`ALTER TABLE "testschema"."tablename" ADD COLUMN "count__widened" bigint`,
+		`UPDATE "testschema"."tablename" SET "count__widened" = "count"`,
+		`ALTER TABLE "testschema"."tablename" DROP COLUMN "count"`,
+		`ALTER TABLE "testschema"."tablename" RENAME COLUMN "count__widened" TO "count"`,
+	} {
+		mock.ExpectPrepare(regex)
+		mock.ExpectExec(regex).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	assert.NoError(t, mockRedshift.WidenColumns(s3filepath.S3File{}, inputTable, targetTable))
 	tx, err := mockRedshift.Begin()
 	assert.NoError(t, err)
-	assert.NoError(t, mockRedshift.UpdateTable(tx, inputTable, fewerColumnsTargetTable))
+	assert.NoError(t, mockRedshift.UpdateTable(tx, s3filepath.S3File{}, inputTable, targetTable))
 	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// narrower input columns are loaded into the wider ones as they are, never narrowing them
+	ops, err := checkSchemas(targetTable, inputTable, false)
+	assert.NoError(t, err)
+	assert.Empty(t, ops)
 }
 
 func TestCheckSchemasSame(t *testing.T) {
@@ -553,7 +1109,7 @@ func TestCheckSchemasSame(t *testing.T) {
 		ColInfo{Name: "DateColumn", PrimaryKey: true},
 	}}
 	t2 := t1
-	columnOps, err := checkSchemas(t1, t2)
+	columnOps, err := checkSchemas(t1, t2, false)
 	assert.Equal(t, 0, len(columnOps))
 	assert.NoError(t, err)
 }
@@ -567,7 +1123,7 @@ func TestCheckSchemasAddColumns(t *testing.T) {
 	t2 := Table{Columns: []ColInfo{
 		ColInfo{Name: "IntColumn", PrimaryKey: true},
 	}}
-	columnOps, err := checkSchemas(inputTable, t2)
+	columnOps, err := checkSchemas(inputTable, t2, false)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(columnOps))
 }
@@ -584,7 +1140,7 @@ func TestCheckSchemasDiffs(t *testing.T) {
 		ColInfo{Name: "IntColumn", Type: "integer"},
 		ColInfo{Name: "IntColumn2", Type: "long"},
 	}}
-	columnOps, err := checkSchemas(inputTable, targetTable)
+	columnOps, err := checkSchemas(inputTable, targetTable, false)
 	assert.Equal(t, 0, len(columnOps))
 	assert.Equal(t, 5, len(err.(*multierror.Error).Errors), fmt.Sprintf("Errors: %s", err))
 }
@@ -601,11 +1157,53 @@ func TestCheckSchemasDifferingSortkey(t *testing.T) {
 		ColInfo{Name: "IntColumn", Type: "integer"},
 		ColInfo{Name: "IntColumn2", Type: "integer", SortOrdinal: 1},
 	}}
-	columnOps, err := checkSchemas(inputTable, targetTable)
+	columnOps, err := checkSchemas(inputTable, targetTable, false)
 	assert.Equal(t, 0, len(columnOps))
 	assert.Equal(t, 1, len(err.(*multierror.Error).Errors), fmt.Sprintf("Errors: %s", err))
 }
 
+func TestCheckSchemasColumnar(t *testing.T) {
+	// columnar input is matched on names, so order doesn't matter
+	inputTable := Table{Columns: []ColInfo{
+		ColInfo{Name: "IntColumn2", Type: "int"},
+		ColInfo{Name: "IntColumn", Type: "int"},
+		ColInfo{Name: "IntColumn3", Type: "int"},
+	}}
+	targetTable := Table{Columns: []ColInfo{
+		ColInfo{Name: "IntColumn", Type: "integer"},
+		ColInfo{Name: "IntColumn2", Type: "integer"},
+	}}
+	columnOps, err := checkSchemas(inputTable, targetTable, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(columnOps))
+}
+
+func TestCheckSchemasDifferingStyles(t *testing.T) {
+	inputTable := Table{
+		Columns: []ColInfo{
+			ColInfo{Name: "IntColumn", Type: "int", SortOrdinal: 1},
+			ColInfo{Name: "IntColumn2", Type: "int", SortOrdinal: 2},
+		},
+		Meta: Meta{SortStyle: SortInterleaved, DistStyle: DistAll},
+	}
+	targetTable := Table{
+		Columns: []ColInfo{
+			ColInfo{Name: "IntColumn", Type: "integer", SortOrdinal: 1},
+			ColInfo{Name: "IntColumn2", Type: "integer", SortOrdinal: 2},
+		},
+		Meta: Meta{SortStyle: SortCompound, DistStyle: DistEven},
+	}
+	columnOps, err := checkSchemas(inputTable, targetTable, false)
+	assert.Equal(t, 0, len(columnOps))
+	assert.Equal(t, 2, len(err.(*multierror.Error).Errors), fmt.Sprintf("Errors: %s", err))
+
+	// not setting styles in the input is fine
+	inputTable.Meta = Meta{}
+	columnOps, err = checkSchemas(inputTable, targetTable, false)
+	assert.Equal(t, 0, len(columnOps))
+	assert.NoError(t, err)
+}
+
 func TestReorder(t *testing.T) {
 	inputTable := Table{Columns: []ColInfo{
 		ColInfo{Name: "IntColumn2", Type: "int"},
@@ -615,7 +1213,61 @@ func TestReorder(t *testing.T) {
 		ColInfo{Name: "IntColumn", Type: "integer"},
 		ColInfo{Name: "IntColumn2", Type: "integer"},
 	}}
-	columnOps, err := checkSchemas(inputTable, targetTable)
+	columnOps, err := checkSchemas(inputTable, targetTable, false)
 	assert.Equal(t, 0, len(columnOps))
 	assert.Equal(t, 2, len(err.(*multierror.Error).Errors), fmt.Sprintf("Errors: %s", err))
 }
+
+func TestDryRun(t *testing.T) {
+	schema, table := "testschema", "tablename"
+	dbTable := Table{
+		Name: table,
+		Columns: []ColInfo{
+			{"id", "text", "", false, true, true, 1, "", ""},
+			{"count", "bigint", "", false, false, false, 0, "", ""},
+		},
+		Meta: Meta{Schema: schema, DataDateColumn: "time"},
+	}
+	s3File := s3filepath.S3File{
+		Bucket:   s3filepath.S3Bucket{Name: "bucket", Region: "us-east-1", RedshiftRoleARN: "role"},
+		Schema:   schema,
+		Table:    table,
+		Suffix:   "json.gz",
+		DataDate: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+	mockRedshift.DryRun()
+
+	// nothing but the transaction itself reaches the database
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.CreateTable(tx, dbTable))
+	_, err = mockRedshift.TruncateInTimeRange(tx, schema, table, "time", s3File.DataDate, s3File.DataDate.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.Copy(tx, s3File, CopyOptions{}, "", true, true))
+	assert.NoError(t, mockRedshift.UpdateLatencyInfo(tx, dbTable))
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	planned := mockRedshift.Planned()
+	assert.Len(t, planned, 5)
+	assert.Regexp(t, `^CREATE TABLE "testschema"."tablename"`, planned[0].SQL)
+	assert.Equal(t, `DELETE FROM "testschema"."tablename" WHERE "time" >= '2020-01-02 00:00:00' AND "time" < '2020-01-03 00:00:00'`, planned[1].SQL)
+	assert.Regexp(t, `^COPY "testschema"."tablename" FROM 's3://bucket/`, planned[2].SQL)
+	assert.False(t, planned[2].OutsideTx)
+	assert.Regexp(t, `^INSERT INTO latencies`, planned[3].SQL)
+	assert.Equal(t, PlannedStatement{
+		SQL:       `UPDATE latencies SET last_update = current_timestamp WHERE name = 'testschema.tablename'`,
+		OutsideTx: true,
+	}, planned[4])
+
+	// the plan is handed over only once
+	assert.Empty(t, mockRedshift.Planned())
+}
@@ -0,0 +1,215 @@
+package redshift
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// colType is a parsed Redshift column type
+type colType struct {
+	// name is the type's name as pg_catalog.format_type prints it, e.g. "character varying"
+	name string
+	// params are the length of character and binary types, or the precision and scale of numerics
+	params []int
+}
+
+// typeParams describes how many parameters a parameterized type takes and what they default to
+type typeParams struct {
+	defaults []int
+	// max is the largest allowed value of the first parameter
+	max int
+}
+
+var (
+	typeRegex = regexp.MustCompile(`^([a-z][a-z0-9 ]*?)\s*(?:\(\s*(\d+|max)\s*(?:,\s*(\d+)\s*)?\))?$`)
+
+	// map between the names redshift accepts for a type and the name format_type uses for it
+	typeNames = map[string]string{
+		"smallint":                    "smallint",
+		"int2":                        "smallint",
+		"integer":                     "integer",
+		"int4":                        "integer",
+		"bigint":                      "bigint",
+		"int8":                        "bigint",
+		"real":                        "real",
+		"float4":                      "real",
+		"float8":                      "double precision",
+		"double precision":            "double precision",
+		"decimal":                     "numeric",
+		"numeric":                     "numeric",
+		"boolean":                     "boolean",
+		"bool":                        "boolean",
+		"date":                        "date",
+		"varchar":                     "character varying",
+		"character varying":           "character varying",
+		"nvarchar":                    "character varying",
+		"char":                        "character",
+		"character":                   "character",
+		"nchar":                       "character",
+		"bpchar":                      "character",
+		"timestamp":                   "timestamp without time zone",
+		"timestamp without time zone": "timestamp without time zone",
+		"timestamptz":                 "timestamp with time zone",
+		"timestamp with time zone":    "timestamp with time zone",
+		"time":                        "time without time zone",
+		"time without time zone":      "time without time zone",
+		"timetz":                      "time with time zone",
+		"time with time zone":         "time with time zone",
+		"super":                       "super",
+		"varbyte":                     "varbyte",
+		"varbinary":                   "varbyte",
+		"binary varying":              "varbyte",
+		"geometry":                    "geometry",
+		"geography":                   "geography",
+		"hllsketch":                   "hllsketch",
+	}
+
+	// parameterized types, keyed on their format_type name
+	typeParamDefaults = map[string]typeParams{
+		"character varying": {defaults: []int{256}, max: 65535},
+		"character":         {defaults: []int{1}, max: 4096},
+		"numeric":           {defaults: []int{18, 0}, max: 38},
+		"varbyte":           {defaults: []int{64000}, max: 1024000},
+	}
+)
+
+// parseType parses a column type from a table config or from the catalog. It accepts the
+// config shorthands in typeMapping, as well as any of the names redshift accepts for a type,
+// with or without parameters, e.g. "decimal(18,4)", "varchar(1024)" or "timestamptz".
+func parseType(s string) (colType, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	if mapped, ok := typeMapping[normalized]; ok {
+		normalized = mapped
+	}
+
+	match := typeRegex.FindStringSubmatch(normalized)
+	if match == nil {
+		return colType{}, fmt.Errorf("unsupported type %q", s)
+	}
+	name, ok := typeNames[match[1]]
+	if !ok {
+		return colType{}, fmt.Errorf("unsupported type %q", s)
+	}
+
+	params, isParameterized := typeParamDefaults[name]
+	if !isParameterized {
+		if match[2] != "" {
+			return colType{}, fmt.Errorf("type %q doesn't take parameters", s)
+		}
+		return colType{name: name}, nil
+	}
+
+	t := colType{name: name, params: append([]int{}, params.defaults...)}
+	switch {
+	case match[2] == "max":
+		t.params[0] = params.max
+	case match[2] != "":
+		t.params[0], _ = strconv.Atoi(match[2])
+	}
+	if match[3] != "" {
+		if len(t.params) < 2 {
+			return colType{}, fmt.Errorf("type %q takes a single parameter", s)
+		}
+		t.params[1], _ = strconv.Atoi(match[3])
+	}
+	if t.params[0] < 1 || t.params[0] > params.max {
+		return colType{}, fmt.Errorf("type %q must have a size between 1 and %d", s, params.max)
+	}
+	if len(t.params) == 2 && t.params[1] > t.params[0] {
+		return colType{}, fmt.Errorf("type %q can't have a scale larger than its precision", s)
+	}
+	return t, nil
+}
+
+// String returns the type the way pg_catalog.format_type prints it
+func (t colType) String() string {
+	if len(t.params) == 0 {
+		return t.name
+	}
+	params := make([]string, len(t.params))
+	for i, p := range t.params {
+		params[i] = strconv.Itoa(p)
+	}
+	return fmt.Sprintf("%s(%s)", t.name, strings.Join(params, ","))
+}
+
+// isVarchar returns whether the type is a varchar of any length
+func (t colType) isVarchar() bool {
+	return t.name == "character varying"
+}
+
+// typeSQL returns the normalized type for a column's configured type. Config types are validated
+// by GetTableFromConf, so anything unparseable is passed through for redshift to complain about.
+func typeSQL(configType string) string {
+	t, err := parseType(configType)
+	if err != nil {
+		return configType
+	}
+	return t.String()
+}
+
+// typeChange classifies the difference between an input column's type and the target's
+type typeChange int
+
+const (
+	typeSame typeChange = iota
+	// typeWidenInPlace can be applied with ALTER TABLE ... ALTER COLUMN ... TYPE
+	typeWidenInPlace
+	// typeWiden needs the column to be swapped for a wider copy of itself
+	typeWiden
+	// typeNarrower is narrower than the target type, which holds its values as is
+	typeNarrower
+	// typeIncompatible would lose data, or isn't something we know how to convert
+	typeIncompatible
+)
+
+var (
+	// ranks of the types that can be widened to any type of a higher rank in the same family
+	integerRanks = map[string]int{"smallint": 1, "integer": 2, "bigint": 3}
+	floatRanks   = map[string]int{"real": 1, "double precision": 2}
+)
+
+// compareTypes returns how the target type needs to change to hold values of the input type
+func compareTypes(in, target colType) typeChange {
+	if in.String() == target.String() {
+		return typeSame
+	}
+	switch {
+	case in.isVarchar() && target.isVarchar():
+		if in.params[0] > target.params[0] {
+			return typeWidenInPlace
+		}
+		return typeNarrower
+	case in.name == "character varying" && target.name == "character":
+		if in.params[0] >= target.params[0] {
+			return typeWiden
+		}
+	case in.name == target.name && (in.name == "character" || in.name == "varbyte"):
+		return compareSizes(in.params[0], target.params[0])
+	case in.name == "numeric" && target.name == "numeric":
+		// the wider type needs room for at least as many digits on both sides of the decimal point
+		inScale, targetScale := in.params[1], target.params[1]
+		inDigits, targetDigits := in.params[0]-inScale, target.params[0]-targetScale
+		if inScale >= targetScale && inDigits >= targetDigits {
+			return typeWiden
+		}
+		if inScale <= targetScale && inDigits <= targetDigits {
+			return typeNarrower
+		}
+	case integerRanks[in.name] > 0 && integerRanks[target.name] > 0:
+		return compareSizes(integerRanks[in.name], integerRanks[target.name])
+	case floatRanks[in.name] > 0 && floatRanks[target.name] > 0:
+		return compareSizes(floatRanks[in.name], floatRanks[target.name])
+	}
+	return typeIncompatible
+}
+
+// compareSizes compares types of the same family by their sizes or ranks, which differ
+func compareSizes(in, target int) typeChange {
+	if in > target {
+		return typeWiden
+	}
+	return typeNarrower
+}
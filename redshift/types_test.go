@@ -0,0 +1,157 @@
+package redshift
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		// config shorthands
+		{"int", "integer"},
+		{"float", "double precision"},
+		{"text", "character varying(256)"},
+		{"longtext", "character varying(65535)"},
+		{"timestamp", "timestamp without time zone"},
+		// redshift names and aliases
+		{"smallint", "smallint"},
+		{"INT8", "bigint"},
+		{"real", "real"},
+		{"bool", "boolean"},
+		{"decimal(18,4)", "numeric(18,4)"},
+		{"numeric", "numeric(18,0)"},
+		{"numeric(10)", "numeric(10,0)"},
+		{"varchar(1024)", "character varying(1024)"},
+		{"varchar(max)", "character varying(65535)"},
+		{"character  varying ( 12 )", "character varying(12)"},
+		{"char(3)", "character(3)"},
+		{"char", "character(1)"},
+		{"timestamptz", "timestamp with time zone"},
+		{"time", "time without time zone"},
+		{"timetz", "time with time zone"},
+		{"super", "super"},
+		{"varbyte(100)", "varbyte(100)"},
+		{"geometry", "geometry"},
+		// format_type output parses back to itself
+		{"character varying(256)", "character varying(256)"},
+		{"timestamp without time zone", "timestamp without time zone"},
+	}
+	for _, test := range tests {
+		parsed, err := parseType(test.in)
+		if assert.NoError(t, err, test.in) {
+			assert.Equal(t, test.out, parsed.String(), test.in)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"long",
+		"varchar(0)",
+		"varchar(70000)",
+		"varchar(10,2)",
+		"integer(5)",
+		"numeric(5,6)",
+		"numeric(39)",
+		"text(10)",
+	} {
+		_, err := parseType(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCheckColumnTypes(t *testing.T) {
+	// equivalent spellings match
+	assert.NoError(t, checkColumn(ColInfo{Name: "a", Type: "decimal(18,4)"}, ColInfo{Name: "a", Type: "numeric(18,4)"}))
+	assert.NoError(t, checkColumn(ColInfo{Name: "a", Type: "timestamptz"}, ColInfo{Name: "a", Type: "timestamp with time zone"}))
+	// varchar lengths are ignored
+	assert.NoError(t, checkColumn(ColInfo{Name: "a", Type: "text"}, ColInfo{Name: "a", Type: "character varying(1024)"}))
+	// as are other narrower input types, which the target holds as is
+	assert.NoError(t, checkColumn(ColInfo{Name: "a", Type: "char(2)"}, ColInfo{Name: "a", Type: "character(3)"}))
+	assert.NoError(t, checkColumn(ColInfo{Name: "a", Type: "smallint"}, ColInfo{Name: "a", Type: "integer"}))
+	// but not ones that don't fit either way
+	assert.Error(t, checkColumn(ColInfo{Name: "a", Type: "decimal(18,4)"}, ColInfo{Name: "a", Type: "numeric(18,2)"}))
+	assert.Error(t, checkColumn(ColInfo{Name: "a", Type: "bigint"}, ColInfo{Name: "a", Type: "real"}))
+}
+
+func TestCompareTypes(t *testing.T) {
+	for _, tc := range []struct {
+		in, target string
+		change     typeChange
+	}{
+		{"varchar(10)", "character varying(10)", typeSame},
+		{"varchar(10)", "character varying(20)", typeNarrower},
+		{"text", "character varying(20)", typeWidenInPlace},
+		{"smallint", "integer", typeNarrower},
+		{"bigint", "integer", typeWiden},
+		{"int4", "smallint", typeWiden},
+		{"float", "real", typeWiden},
+		{"real", "double precision", typeNarrower},
+		{"decimal(20,4)", "numeric(18,4)", typeWiden},
+		{"decimal(20,4)", "numeric(18,2)", typeWiden},
+		{"decimal(18,4)", "numeric(18,2)", typeIncompatible},
+		{"decimal(16,2)", "numeric(18,4)", typeNarrower},
+		{"char(2)", "character(4)", typeNarrower},
+		{"char(4)", "character(2)", typeWiden},
+		{"varchar(4)", "character(2)", typeWiden},
+		{"varchar(1)", "character(2)", typeIncompatible},
+		{"bigint", "character varying(256)", typeIncompatible},
+		{"varbyte(100)", "varbyte(50)", typeWiden},
+	} {
+		in, err := parseType(tc.in)
+		assert.NoError(t, err)
+		target, err := parseType(tc.target)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.change, compareTypes(in, target), "%s -> %s", tc.target, tc.in)
+	}
+}
+
+func TestWidenColumn(t *testing.T) {
+	target := Table{Name: "t", Meta: Meta{Schema: "s"}}
+
+	// varchars are widened in place, outside of the transaction
+	ops, err := widenColumn(target, ColInfo{Name: "a", Type: "varchar(100)"}, ColInfo{Name: "a", Type: "character varying(10)"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []columnOp{{sql: `ALTER TABLE "s"."t" ALTER COLUMN "a" TYPE character varying(100)`, outsideTx: true}}, ops)
+
+	// everything else is swapped for a wider column
+	ops, err = widenColumn(target, ColInfo{Name: "a", Type: "bigint"}, ColInfo{Name: "a", Type: "integer"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []columnOp{
+		{sql: `ALTER TABLE "s"."t" ADD COLUMN  "a__widened" bigint   `},
+		{sql: `UPDATE "s"."t" SET "a__widened" = "a"`},
+		{sql: `ALTER TABLE "s"."t" DROP COLUMN "a"`},
+		{sql: `ALTER TABLE "s"."t" RENAME COLUMN "a__widened" TO "a"`},
+	}, ops)
+
+	// including varchars in encodings redshift can't alter
+	for _, encoding := range []string{"bytedict", "runlength", "text255", "text32k"} {
+		ops, err = widenColumn(target, ColInfo{Name: "a", Type: "varchar(100)"}, ColInfo{Name: "a", Type: "character varying(10)", Encoding: encoding}, true)
+		assert.NoError(t, err)
+		if assert.Len(t, ops, 4, encoding) {
+			assert.Equal(t, `ALTER TABLE "s"."t" ADD COLUMN  "a__widened" character varying(100)   `, ops[0].sql)
+			assert.False(t, ops[0].outsideTx)
+		}
+	}
+	ops, err = widenColumn(target, ColInfo{Name: "a", Type: "varchar(100)"}, ColInfo{Name: "a", Type: "character varying(10)", Encoding: "lzo"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []columnOp{{sql: `ALTER TABLE "s"."t" ALTER COLUMN "a" TYPE character varying(100)`, outsideTx: true}}, ops)
+
+	// unless that would move it, or lose its keys
+	for _, targetCol := range []ColInfo{
+		{Name: "a", Type: "integer", SortOrdinal: 1},
+		{Name: "a", Type: "integer", DistKey: true},
+		{Name: "a", Type: "integer", PrimaryKey: true},
+		{Name: "a", Type: "integer", NotNull: true},
+	} {
+		inCol := targetCol
+		inCol.Type = "bigint"
+		_, err = widenColumn(target, inCol, targetCol, true)
+		assert.IsType(t, rebuildError{}, err)
+	}
+	_, err = widenColumn(target, ColInfo{Name: "a", Type: "bigint"}, ColInfo{Name: "a", Type: "integer"}, false)
+	assert.IsType(t, rebuildError{}, err)
+}
@@ -0,0 +1,54 @@
+package redshift
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Clever/pq"
+)
+
+// WatermarksTable keeps how far each table has been loaded with stream granularity, so the next
+// stream load can start from there. It's created by the first load that sets a watermark.
+const WatermarksTable = "stream_watermarks"
+
+const watermarksTableSQL = `CREATE TABLE IF NOT EXISTS ` + WatermarksTable + ` (
+	schema_name varchar(128) NOT NULL,
+	table_name varchar(128) NOT NULL,
+	watermark timestamp NOT NULL,
+	run_id varchar(64) NOT NULL,
+	updated_at timestamp NOT NULL DEFAULT getdate()
+)`
+
+// Watermark returns the end of the time range the table was last loaded with stream granularity,
+// or nil if it never was
+func (r *Redshift) Watermark(schema, table string) (*time.Time, error) {
+	query := fmt.Sprintf("SELECT watermark FROM %s WHERE schema_name = %s AND table_name = %s",
+		WatermarksTable, quoteLiteral(schema), quoteLiteral(table))
+	var watermark time.Time
+	err := r.QueryRowContext(r.ctx, query).Scan(&watermark)
+	if pqErr, ok := err.(*pq.Error); (ok && pqErr.Code == undefinedTable) || err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error looking up the watermark of %s.%s: %s", schema, table, err)
+	}
+	return &watermark, nil
+}
+
+// SetWatermark sets the end of the time range the table was loaded up to with stream granularity.
+// It's meant to be run in the load's transaction, so the watermark only moves if the load commits.
+func (r *Redshift) SetWatermark(tx *sql.Tx, schema, table string, watermark time.Time, runID string) error {
+	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE schema_name = %s AND table_name = %s",
+		WatermarksTable, quoteLiteral(schema), quoteLiteral(table))
+	insertSQL := fmt.Sprintf("INSERT INTO %s (schema_name, table_name, watermark, run_id) VALUES (%s, %s, %s, %s)",
+		WatermarksTable, quoteLiteral(schema), quoteLiteral(table), timestampSQL(&watermark), quoteLiteral(runID))
+	for _, q := range []string{watermarksTableSQL, deleteSQL, insertSQL} {
+		if r.skip(q, false) {
+			continue
+		}
+		if _, err := tx.ExecContext(r.ctx, q); err != nil {
+			return fmt.Errorf("error setting the watermark of %s.%s: %s", schema, table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package redshift
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Clever/pq"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermark(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mockRedshift := Redshift{dbExecCloser: db, ctx: textCtx}
+	query := `SELECT watermark FROM stream_watermarks WHERE schema_name = 's' AND table_name = 't'`
+	watermark := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	// neither a missing table nor a missing row is an error
+	mock.ExpectQuery(query).WillReturnError(&pq.Error{Code: undefinedTable})
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"watermark"}))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"watermark"}).AddRow(watermark))
+	for _, expected := range []*time.Time{nil, nil, &watermark} {
+		returned, err := mockRedshift.Watermark("s", "t")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, returned)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS stream_watermarks`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM stream_watermarks WHERE schema_name = 's' AND table_name = 't'`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stream_watermarks \(schema_name, table_name, watermark, run_id\) ` +
+		`VALUES \('s', 't', '2020-01-02 10:00:00', 'run'\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	tx, err := mockRedshift.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, mockRedshift.SetWatermark(tx, "s", "t", watermark, "run"))
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,239 @@
+package s3filepath
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// datePlaceholders are the layout placeholders a file's data date can be read from
+var datePlaceholders = map[string]string{
+	"yyyy":      `\d{4}`,
+	"mm":        `\d{2}`,
+	"dd":        `\d{2}`,
+	"hh":        `\d{2}`,
+	"timestamp": `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:Z|[+-]\d{2}:\d{2})`,
+}
+
+// Lister is the interface for listing the files in S3 under a prefix, which allows DI for testing.
+type Lister interface {
+	// ListFiles returns the paths of the files whose path starts with prefix, e.g. s3://bucket/some/pre,
+	// including those in directories below it
+	ListFiles(prefix string) ([]string, error)
+}
+
+// ListFiles lists the files under prefix using the S3 API, in the region of the S3PathChecker,
+// including those in directories below it
+func (pc S3PathChecker) ListFiles(prefix string) ([]string, error) {
+	client, err := pc.client(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return objectPaths(listObjects(client, prefix, true))
+}
+
+// ListObjects lists the files under prefix that are in its directory using the S3 API, with
+// their sizes
+func (pc S3PathChecker) ListObjects(prefix string) ([]Object, error) {
+	client, err := pc.client(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return listObjects(client, prefix, false)
+}
+
+// client returns an S3 client in the region of the S3PathChecker, or of the bucket of path if
+// it doesn't have one
+func (pc S3PathChecker) client(path string) (*s3.S3, error) {
+	region := pc.Region
+	if region == "" {
+		bucket, _, err := splitS3Path(path)
+		if err != nil {
+			return nil, err
+		}
+		clients.Lock()
+		region = clients.regions[bucket]
+		clients.Unlock()
+		if region == "" {
+			if region, err = pc.BucketRegion(bucket); err != nil {
+				return nil, err
+			}
+			clients.Lock()
+			clients.regions[bucket] = region
+			clients.Unlock()
+		}
+	}
+	return cachedClient("s3:"+region, aws.NewConfig().WithRegion(region)), nil
+}
+
+func splitS3Path(path string) (string, string, error) {
+	if !strings.HasPrefix(path, "s3://") {
+		return "", "", fmt.Errorf("%s isn't an s3 path", path)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "s3://"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// LatestDataDate finds the data date of the newest input file of a table by listing the files
+// in the bucket that fit the table's layout. If before isn't zero, only files with earlier
+// data dates are considered. Files that don't have one of the suffixes CreateS3File looks
+// for, such as configs, are ignored, as are files without the marker the table requires,
+// which may not be complete yet. If the bucket has a PartPattern, directories the layout puts
+// input files in count too when they have files matching it.
+func LatestDataDate(l Lister, bucket S3Bucket, schema, table string, before time.Time) (time.Time, error) {
+	layout := bucket.LayoutFor(table)
+	if err := ValidateLayout(layout); err != nil {
+		return time.Time{}, err
+	}
+	prefix, fileRegex, err := layoutRegex(layout, schema, table)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	files, err := l.ListFiles(fmt.Sprintf("%s/%s", bucket.URL(), prefix))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// part files can only be dated by their directory, so the layout's directory needs a date
+	var dirRegex *regexp.Regexp
+	if bucket.PartPattern != "" {
+		_, dirRegex, _ = layoutRegex(path.Dir(layout), schema, table)
+	}
+	marker := bucket.MarkerFor(table)
+	listed := map[string]bool{}
+	for _, file := range files {
+		listed[file] = true
+	}
+	var latest time.Time
+	for _, file := range files {
+		date, ok := inputDate(fileRegex, bucket, file)
+		if !ok && dirRegex != nil {
+			date, ok = partsDate(dirRegex, bucket, file)
+			// the parts' marker is next to where the layout puts the manifest written for them
+			file = fmt.Sprintf("%s/%s", bucket.URL(), renderLayout(layout, schema, table, date, "manifest"))
+		}
+		if !ok {
+			continue
+		}
+		if marker != "" && !listed[markerPath(file, marker)] {
+			continue
+		}
+		if (before.IsZero() || date.Before(before)) && date.After(latest) {
+			latest = date
+		}
+	}
+	if latest.IsZero() {
+		return latest, notFound("no input files found for bucket: %s schema: %s, table: %s", bucket.Name, schema, table)
+	}
+	return latest, nil
+}
+
+// inputDate returns the data date of an input file the layout puts in the bucket, and whether
+// file is one
+func inputDate(fileRegex *regexp.Regexp, bucket S3Bucket, file string) (time.Time, bool) {
+	match := fileRegex.FindStringSubmatch(strings.TrimPrefix(file, bucket.URL()+"/"))
+	if match == nil || !isInputSuffix(match[fileRegex.SubexpIndex("suffix")]) {
+		return time.Time{}, false
+	}
+	date, err := matchDate(fileRegex, match)
+	return date, err == nil
+}
+
+// partsDate returns the data date of the directory a part file is in, and whether file is a part
+// file in a directory the layout puts input files in
+func partsDate(dirRegex *regexp.Regexp, bucket S3Bucket, file string) (time.Time, bool) {
+	dir, name := path.Split(strings.TrimPrefix(file, bucket.URL()+"/"))
+	if matched, err := path.Match(bucket.PartPattern, name); err != nil || !matched {
+		return time.Time{}, false
+	}
+	match := dirRegex.FindStringSubmatch(strings.TrimSuffix(dir, "/"))
+	if match == nil {
+		return time.Time{}, false
+	}
+	date, err := matchDate(dirRegex, match)
+	return date, err == nil
+}
+
+// layoutRegex returns the part of a table's layout before its first date placeholder, which
+// is the prefix all the table's files share, and a regex matching the table's files
+func layoutRegex(layout, schema, table string) (string, *regexp.Regexp, error) {
+	var prefix, expr strings.Builder
+	seen := map[string]bool{}
+	hasDate := false
+	last := 0
+	for _, loc := range layoutPlaceholder.FindAllStringSubmatchIndex(layout, -1) {
+		literal := layout[last:loc[0]]
+		name := layout[loc[2]:loc[3]]
+		last = loc[1]
+
+		expr.WriteString(regexp.QuoteMeta(literal))
+		if !hasDate {
+			prefix.WriteString(literal)
+		}
+		var value string
+		switch name {
+		case "schema":
+			value = schema
+		case "table":
+			value = table
+		}
+		if value != "" {
+			expr.WriteString(regexp.QuoteMeta(value))
+			if !hasDate {
+				prefix.WriteString(value)
+			}
+			continue
+		}
+		if pattern, ok := datePlaceholders[name]; ok {
+			hasDate = true
+			if seen[name] {
+				// repeats can't be captured under the same name again
+				expr.WriteString(pattern)
+			} else {
+				expr.WriteString(fmt.Sprintf("(?P<%s>%s)", name, pattern))
+			}
+		} else if name == "suffix" && !seen[name] {
+			expr.WriteString(`(?P<suffix>.*)`)
+		} else {
+			expr.WriteString(`.*`)
+		}
+		seen[name] = true
+	}
+	expr.WriteString(regexp.QuoteMeta(layout[last:]))
+	if !hasDate {
+		return "", nil, fmt.Errorf("layout %s has no date placeholders to find the latest file by", layout)
+	}
+	re, err := regexp.Compile("^" + expr.String() + "$")
+	return prefix.String(), re, err
+}
+
+// matchDate reads the data date out of the placeholders matched in a file's path
+func matchDate(re *regexp.Regexp, match []string) (time.Time, error) {
+	if i := re.SubexpIndex("timestamp"); i >= 0 {
+		return time.Parse(time.RFC3339, match[i])
+	}
+	parts := map[string]int{"yyyy": 0, "mm": 1, "dd": 1, "hh": 0}
+	for name := range parts {
+		if i := re.SubexpIndex(name); i >= 0 {
+			n, err := strconv.Atoi(match[i])
+			if err != nil {
+				return time.Time{}, err
+			}
+			parts[name] = n
+		}
+	}
+	date := time.Date(parts["yyyy"], time.Month(parts["mm"]), parts["dd"], parts["hh"], 0, 0, 0, time.UTC)
+	if date.Month() != time.Month(parts["mm"]) || date.Day() != parts["dd"] || date.Hour() != parts["hh"] {
+		return time.Time{}, fmt.Errorf("invalid date in %s", match[0])
+	}
+	return date, nil
+}
@@ -0,0 +1,141 @@
+package s3filepath
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockLister struct {
+	Files []string
+}
+
+func (ml MockLister) ListFiles(prefix string) ([]string, error) {
+	var files []string
+	for _, f := range ml.Files {
+		if strings.HasPrefix(f, prefix) {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+type ErrLister struct{}
+
+func (ErrLister) ListFiles(prefix string) ([]string, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestLatestDataDate(t *testing.T) {
+	bucket := S3Bucket{Name: "b"}
+	lister := MockLister{[]string{
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=09/s_t_2015-11-09T23:00:00Z.json.gz",
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/s_t_2015-11-10T23:00:00Z.manifest",
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/s_t_2015-11-10T23:00:00Z.gz",
+		// configs and unknown files don't count
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=11/config_s_t_2015-11-11T23:00:00Z.yml",
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=11/s_t_2015-11-11T23:00:00Z.tmp",
+		// nor other tables
+		"s3://b/s/t2/_data_timestamp_year=2016/_data_timestamp_month=01/_data_timestamp_day=01/s_t2_2016-01-01T00:00:00Z.json",
+	}}
+
+	date, err := LatestDataDate(lister, bucket, "s", "t", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDate, date)
+
+	date, err = LatestDataDate(lister, bucket, "s", "t", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDate.Add(-24*time.Hour), date)
+
+	_, err = LatestDataDate(lister, bucket, "s", "t", expectedDate.Add(-24*time.Hour))
+	assert.Error(t, err)
+	_, err = LatestDataDate(ErrLister{}, bucket, "s", "t", time.Time{})
+	assert.Error(t, err)
+}
+
+func TestLatestDataDateMarker(t *testing.T) {
+	lister := MockLister{[]string{
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=09/s_t_2015-11-09T23:00:00Z.json.gz",
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=09/_SUCCESS",
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=09/s_t_2015-11-09T23:00:00Z.json.gz.done",
+		// the newest file isn't complete yet
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/s_t_2015-11-10T23:00:00Z.json.gz",
+	}}
+
+	for _, bucket := range []S3Bucket{
+		{Name: "b", Marker: "_SUCCESS"},
+		{Name: "b", Marker: "{file}.done"},
+		{Name: "b", TableMarkers: map[string]string{"t": "_SUCCESS"}},
+	} {
+		date, err := LatestDataDate(lister, bucket, "s", "t", time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, expectedDate.Add(-24*time.Hour), date, "%+v", bucket)
+	}
+
+	_, err := LatestDataDate(lister, S3Bucket{Name: "b", Marker: "_DONE"}, "s", "t", time.Time{})
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+func TestLatestDataDateLayout(t *testing.T) {
+	bucket := S3Bucket{Name: "b", Layout: "firehose/{table}/{yyyy}/{mm}/{dd}/{hh}/{table}.{suffix}"}
+	lister := MockLister{[]string{
+		"s3://b/firehose/t/2015/11/10/22/t.json.gz",
+		"s3://b/firehose/t/2015/11/10/23/t.json.gz",
+		"s3://b/firehose/t/2015/11/10/23/t.manifest",
+		"s3://b/firehose/t/2015/13/10/23/t.json.gz",
+	}}
+	date, err := LatestDataDate(lister, bucket, "s", "t", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDate, date)
+
+	// a layout without dates can't tell which file is the latest
+	bucket.Layout = "{table}/latest.{suffix}"
+	_, err = LatestDataDate(lister, bucket, "s", "t", time.Time{})
+	assert.Error(t, err)
+}
+
+func TestLatestDataDateParts(t *testing.T) {
+	bucket := S3Bucket{Name: "b", Layout: "firehose/{table}/{yyyy}/{mm}/{dd}/{hh}/{table}.{suffix}", PartPattern: "part-*"}
+	lister := MockLister{[]string{
+		"s3://b/firehose/t/2015/11/10/22/t.json.gz",
+		"s3://b/firehose/t/2015/11/10/23/part-0000.json.gz",
+		"s3://b/firehose/t/2015/11/10/23/part-0001.json.gz",
+		"s3://b/firehose/t/2015/11/10/23/_SUCCESS",
+		// parts outside of the layout's directories don't count
+		"s3://b/firehose/t/2015/11/11/part-0000.json.gz",
+		"s3://b/firehose/t/2015/11/12/00/extra/part-0000.json.gz",
+	}}
+	date, err := LatestDataDate(lister, bucket, "s", "t", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDate, date)
+
+	// the parts need the marker too
+	bucket.Marker = "_SUCCESS"
+	date, err = LatestDataDate(lister, bucket, "s", "t", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDate, date)
+	bucket.Marker = "_DONE"
+	_, err = LatestDataDate(lister, bucket, "s", "t", time.Time{})
+	assert.IsType(t, &NotFoundError{}, err)
+
+	// and without a part pattern they're ignored
+	bucket.Marker, bucket.PartPattern = "", ""
+	date, err = LatestDataDate(lister, bucket, "s", "t", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDate.Add(-time.Hour), date)
+}
+
+func TestLayoutRegex(t *testing.T) {
+	prefix, re, err := layoutRegex(DefaultLayout, "s", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, "s/t/_data_timestamp_year=", prefix)
+	match := re.FindStringSubmatch("s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/s_t_2015-11-10T23:00:00Z.")
+	if assert.NotNil(t, match) {
+		assert.Equal(t, "", match[re.SubexpIndex("suffix")])
+		assert.Equal(t, "2015-11-10T23:00:00Z", match[re.SubexpIndex("timestamp")])
+	}
+	assert.Nil(t, re.FindStringSubmatch("s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/config_s_t_2015-11-10T23:00:00Z.yml"))
+}
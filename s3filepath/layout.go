@@ -0,0 +1,69 @@
+package s3filepath
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultLayout is where files are in a bucket unless told otherwise
+const DefaultLayout = "{schema}/{table}/_data_timestamp_year={yyyy}/_data_timestamp_month={mm}/_data_timestamp_day={dd}/{schema}_{table}_{timestamp}.{suffix}"
+
+var layoutPlaceholder = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// layoutValues are the placeholders a layout can use, and how to fill them in
+var layoutValues = map[string]func(schema, table string, date time.Time, suffix string) string{
+	"schema":    func(schema, table string, date time.Time, suffix string) string { return schema },
+	"table":     func(schema, table string, date time.Time, suffix string) string { return table },
+	"yyyy":      func(schema, table string, date time.Time, suffix string) string { return date.Format("2006") },
+	"mm":        func(schema, table string, date time.Time, suffix string) string { return date.Format("01") },
+	"dd":        func(schema, table string, date time.Time, suffix string) string { return date.Format("02") },
+	"hh":        func(schema, table string, date time.Time, suffix string) string { return date.Format("15") },
+	"timestamp": func(schema, table string, date time.Time, suffix string) string { return date.Format(time.RFC3339) },
+	"suffix":    func(schema, table string, date time.Time, suffix string) string { return suffix },
+}
+
+// ValidateLayout checks that a layout only uses known placeholders, and that it has
+// a {suffix} in its file name so the different kinds of input files can be found
+func ValidateLayout(layout string) error {
+	for _, match := range layoutPlaceholder.FindAllStringSubmatch(layout, -1) {
+		if _, ok := layoutValues[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder %s in layout %s", match[0], layout)
+		}
+	}
+	if !strings.Contains(path.Base(layout), "{suffix}") {
+		return fmt.Errorf("layout %s needs a {suffix} in its file name", layout)
+	}
+	return nil
+}
+
+// LayoutFor returns the layout of a table's files in the bucket
+func (b S3Bucket) LayoutFor(table string) string {
+	if layout, ok := b.TableLayouts[table]; ok {
+		return layout
+	}
+	if b.Layout != "" {
+		return b.Layout
+	}
+	return DefaultLayout
+}
+
+// renderLayout fills in a layout's placeholders, returning the path of the file within its bucket
+func renderLayout(layout, schema, table string, date time.Time, suffix string) string {
+	return layoutPlaceholder.ReplaceAllStringFunc(layout, func(placeholder string) string {
+		value, ok := layoutValues[strings.Trim(placeholder, "{}")]
+		if !ok {
+			return placeholder
+		}
+		return value(schema, table, date, suffix)
+	})
+}
+
+// configPath returns where the config for a file is by default: next to it, named
+// like a yml file of the same layout with a "config_" prefix
+func configPath(layout, schema, table string, date time.Time) string {
+	dir, file := path.Split(renderLayout(layout, schema, table, date, "yml"))
+	return dir + "config_" + file
+}
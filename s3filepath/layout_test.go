@@ -0,0 +1,51 @@
+package s3filepath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderLayout(t *testing.T) {
+	assert.Equal(t, "s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/s_t_2015-11-10T23:00:00Z.json.gz",
+		renderLayout(DefaultLayout, "s", "t", expectedDate, "json.gz"))
+	assert.Equal(t, "events/s.t/2015/11/10/23/part.parquet",
+		renderLayout("events/{schema}.{table}/{yyyy}/{mm}/{dd}/{hh}/part.{suffix}", "s", "t", expectedDate, "parquet"))
+
+	assert.Equal(t, "s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/config_s_t_2015-11-10T23:00:00Z.yml",
+		configPath(DefaultLayout, "s", "t", expectedDate))
+	assert.Equal(t, "dms/t/2015/11/10/config_LOAD.yml", configPath("dms/{table}/{yyyy}/{mm}/{dd}/LOAD.{suffix}", "s", "t", expectedDate))
+}
+
+func TestValidateLayout(t *testing.T) {
+	assert.NoError(t, ValidateLayout(DefaultLayout))
+	assert.NoError(t, ValidateLayout("{table}/{yyyy}{mm}{dd}.{suffix}"))
+	assert.Error(t, ValidateLayout("{table}/{year}/{dd}.{suffix}"))
+	assert.Error(t, ValidateLayout("{table}/{}/{dd}.{suffix}"))
+	// the suffix has to be in the file name
+	assert.Error(t, ValidateLayout("{table}/{dd}.json"))
+	assert.Error(t, ValidateLayout("{table}.{suffix}/{dd}"))
+}
+
+func TestLayoutFor(t *testing.T) {
+	assert.Equal(t, DefaultLayout, S3Bucket{}.LayoutFor("t"))
+	bucket := S3Bucket{Layout: "{table}.{suffix}", TableLayouts: map[string]string{"u": "{table}/{dd}.{suffix}"}}
+	assert.Equal(t, "{table}.{suffix}", bucket.LayoutFor("t"))
+	assert.Equal(t, "{table}/{dd}.{suffix}", bucket.LayoutFor("u"))
+}
+
+func TestCreateS3FileLayout(t *testing.T) {
+	bucket := S3Bucket{Name: "b", Layout: "firehose/{table}/{yyyy}/{mm}/{dd}/{hh}/{table}.{suffix}"}
+	pc := MockPathChecker{map[string]bool{"s3://b/firehose/t/2015/11/10/23/t.json.gz": true}}
+
+	f, err := CreateS3File(pc, bucket, "s", "t", "", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, "json.gz", f.Suffix)
+	assert.Equal(t, "firehose/t/2015/11/10/23", f.Subfolder)
+	assert.Equal(t, "s3://b/firehose/t/2015/11/10/23/config_t.yml", f.ConfFile)
+	assert.Equal(t, "s3://b/firehose/t/2015/11/10/23/t.json.gz", f.GetDataFilename())
+
+	bucket.Layout = "{table}/{day}.{suffix}"
+	_, err = CreateS3File(pc, bucket, "s", "t", "", expectedDate)
+	assert.Error(t, err)
+}
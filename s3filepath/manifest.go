@@ -0,0 +1,260 @@
+package s3filepath
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// FormatParquet is the Format of parquet input files
+	FormatParquet = "parquet"
+	// FormatORC is the Format of orc input files
+	FormatORC = "orc"
+)
+
+// Manifest is a Redshift COPY manifest, listing the files to load
+// See https://docs.aws.amazon.com/redshift/latest/dg/loading-data-files-using-manifest.html
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry is a single file in a Manifest
+type ManifestEntry struct {
+	URL       string       `json:"url"`
+	Mandatory bool         `json:"mandatory"`
+	Meta      ManifestMeta `json:"meta,omitempty"`
+}
+
+// ManifestMeta holds the optional per-file metadata of a ManifestEntry.
+// content_length is required by Redshift for columnar files, and record_count
+// is written by UNLOAD with MANIFEST VERBOSE.
+type ManifestMeta struct {
+	ContentLength int64  `json:"content_length,omitempty"`
+	RecordCount   *int64 `json:"record_count,omitempty"`
+}
+
+// ManifestReader is implemented by PathCheckers which can also read manifests, so that
+// CreateS3File can find the format of the files a manifest points at.
+type ManifestReader interface {
+	ReadManifest(path string) (*Manifest, error)
+}
+
+// ParseManifest decodes a manifest file
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %s", err)
+	}
+	return &m, nil
+}
+
+// Format returns the columnar format shared by every entry in the manifest, or ""
+// if the entries are JSON or CSV. Mixing columnar and other files is an error, since
+// a single COPY can only load one format.
+func (m Manifest) Format() (string, error) {
+	if len(m.Entries) == 0 {
+		return "", nil
+	}
+	format := formatForPath(m.Entries[0].URL)
+	for _, e := range m.Entries[1:] {
+		if f := formatForPath(e.URL); f != format {
+			return "", fmt.Errorf("mixed formats in manifest: %s is %q but %s is %q",
+				m.Entries[0].URL, format, e.URL, f)
+		}
+	}
+	return format, nil
+}
+
+// RecordCount returns the total record count of the manifest's entries, which is an error if
+// any entry doesn't have one
+func (m Manifest) RecordCount() (int64, error) {
+	var count int64
+	for _, e := range m.Entries {
+		if e.Meta.RecordCount == nil {
+			return 0, fmt.Errorf("manifest entry %s has no record_count", e.URL)
+		}
+		count += *e.Meta.RecordCount
+	}
+	return count, nil
+}
+
+// formatForPath guesses the columnar format of a file from its path or bare suffix
+func formatForPath(path string) string {
+	for _, format := range []string{FormatParquet, FormatORC} {
+		if path == format || strings.HasSuffix(path, "."+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// compressionSuffixes are the suffixes of the compressions COPY can load, by the compression
+var compressionSuffixes = map[string]string{
+	".gz":  "gzip",
+	".bz2": "bzip2",
+	".lzo": "lzop",
+	".zst": "zstd",
+}
+
+// ManifestProblem is something wrong with a manifest, or with one of its entries if URL is set
+type ManifestProblem struct {
+	URL     string `json:"url,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// ManifestError lists the problems ValidateManifest found with a manifest
+type ManifestError struct {
+	Manifest string            `json:"manifest"`
+	Problems []ManifestProblem `json:"problems"`
+}
+
+func (e *ManifestError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		if p.URL == "" {
+			problems = append(problems, p.Problem)
+		} else {
+			problems = append(problems, fmt.Sprintf("%s: %s", p.URL, p.Problem))
+		}
+	}
+	return fmt.Sprintf("invalid manifest %s: %s", e.Manifest, strings.Join(problems, "; "))
+}
+
+// ValidateManifest checks the manifest at path before it's loaded, so that problems COPY would
+// fail on are reported plainly: it has to have entries, in a single format and compression, and
+// its mandatory entries have to exist with the sizes their content_length says, if set. Each
+// directory entries are in is listed once, but only the files in it that start like all of its
+// entries do, and not the directories below it. Problems are returned as a *ManifestError.
+func ValidateManifest(pc PathChecker, path string) error {
+	mr, canRead := pc.(ManifestReader)
+	lister, canList := pc.(ObjectLister)
+	if !canRead || !canList {
+		return fmt.Errorf("can't validate manifests with %T", pc)
+	}
+	manifest, err := mr.ReadManifest(path)
+	if err != nil {
+		return err
+	}
+	listed, err := listEntries(lister, manifest)
+	if err != nil {
+		return err
+	}
+	return validateManifest(path, manifest, listed)
+}
+
+// ValidateInputManifest checks the manifest the input file is like ValidateManifest, including
+// one built for part files that isn't staged yet. What it lists is kept in the input file's Listed.
+func ValidateInputManifest(pc PathChecker, f *S3File) error {
+	lister, canList := pc.(ObjectLister)
+	if !canList {
+		return fmt.Errorf("can't validate manifests with %T", pc)
+	}
+	manifest, err := InputManifest(pc, *f)
+	if err != nil {
+		return err
+	}
+	if f.Listed == nil {
+		if f.Listed, err = listEntries(lister, manifest); err != nil {
+			return err
+		}
+	}
+	return validateManifest(f.GetDataFilename(), manifest, f.Listed)
+}
+
+// listEntries lists the files the manifest's entries could be, listing as little of each
+// directory as covers its entries
+func listEntries(lister ObjectLister, manifest *Manifest) ([]Object, error) {
+	prefixes := map[string]string{}
+	for _, e := range manifest.Entries {
+		dir := e.URL[:strings.LastIndex(e.URL, "/")+1]
+		if prefix, ok := prefixes[dir]; ok {
+			prefixes[dir] = commonPrefix(prefix, e.URL)
+		} else {
+			prefixes[dir] = e.URL
+		}
+	}
+	listed := []Object{}
+	for _, prefix := range prefixes {
+		objects, err := lister.ListObjects(prefix)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, objects...)
+	}
+	return listed, nil
+}
+
+// validateManifest checks the manifest read from path against the files listed for its entries,
+// see ValidateManifest
+func validateManifest(path string, manifest *Manifest, listed []Object) error {
+	manifestErr := &ManifestError{Manifest: path}
+	problem := func(url, format string, a ...interface{}) {
+		manifestErr.Problems = append(manifestErr.Problems, ManifestProblem{URL: url, Problem: fmt.Sprintf(format, a...)})
+	}
+	if len(manifest.Entries) == 0 {
+		problem("", "manifest has no entries")
+		return manifestErr
+	}
+
+	sizes := map[string]int64{}
+	for _, object := range listed {
+		sizes[object.Path] = object.Size
+	}
+
+	first := manifest.Entries[0]
+	format, compression := entryFormat(first.URL)
+	for _, e := range manifest.Entries {
+		if f, c := entryFormat(e.URL); f != format {
+			problem(e.URL, "format %q doesn't match %q of %s", f, format, first.URL)
+		} else if c != compression {
+			problem(e.URL, "compression %q doesn't match %q of %s", c, compression, first.URL)
+		}
+
+		size, exists := sizes[e.URL]
+		if !exists {
+			if e.Mandatory {
+				problem(e.URL, "mandatory entry doesn't exist")
+			}
+		} else if e.Meta.ContentLength != 0 && size != e.Meta.ContentLength {
+			problem(e.URL, "size %d doesn't match content_length %d", size, e.Meta.ContentLength)
+		}
+	}
+	if len(manifestErr.Problems) > 0 {
+		return manifestErr
+	}
+	return nil
+}
+
+// commonPrefix returns the longest prefix a and b share
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// entryFormat returns the format of a manifest entry from its extension, and its compression,
+// e.g. "json" and "gzip" for a.json.gz. Columnar formats are compressed inside the file instead.
+func entryFormat(url string) (string, string) {
+	if format := formatForPath(url); format != "" {
+		return format, ""
+	}
+	name := path.Base(url)
+	compression := ""
+	for suffix, c := range compressionSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			compression = c
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+	format := ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		format = name[i+1:]
+	}
+	return format, compression
+}
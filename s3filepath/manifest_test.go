@@ -0,0 +1,138 @@
+package s3filepath
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestFormat(t *testing.T) {
+	tests := []struct {
+		manifest string
+		format   string
+		hasErr   bool
+	}{
+		{`{"entries": []}`, "", false},
+		{`{"entries": [{"url": "s3://b/a.json.gz"}, {"url": "s3://b/b.json.gz"}]}`, "", false},
+		{`{"entries": [{"url": "s3://b/a.parquet", "meta": {"content_length": 5}}, {"url": "s3://b/b.parquet"}]}`, FormatParquet, false},
+		{`{"entries": [{"url": "s3://b/a.snappy.orc"}]}`, FormatORC, false},
+		{`{"entries": [{"url": "s3://b/a.parquet"}, {"url": "s3://b/b.json"}]}`, "", true},
+	}
+	for _, test := range tests {
+		m, err := ParseManifest(strings.NewReader(test.manifest))
+		assert.NoError(t, err)
+		format, err := m.Format()
+		if test.hasErr {
+			assert.Error(t, err, test.manifest)
+			continue
+		}
+		assert.NoError(t, err, test.manifest)
+		assert.Equal(t, test.format, format, test.manifest)
+	}
+
+	_, err := ParseManifest(strings.NewReader("not json"))
+	assert.Error(t, err)
+}
+
+func TestEntryFormat(t *testing.T) {
+	for _, tc := range []struct {
+		url, format, compression string
+	}{
+		{"s3://b/a.json.gz", "json", "gzip"},
+		{"s3://b/a.json", "json", ""},
+		{"s3://b/part-00000.gz", "", "gzip"},
+		{"s3://b/part-00000", "", ""},
+		{"s3://b/a.csv.zst", "csv", "zstd"},
+		{"s3://b/a.snappy.parquet", FormatParquet, ""},
+	} {
+		format, compression := entryFormat(tc.url)
+		assert.Equal(t, tc.format, format, tc.url)
+		assert.Equal(t, tc.compression, compression, tc.url)
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3filepath")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	for file, data := range map[string]string{
+		"a/part-0.json.gz": "aaaa",
+		"a/part-1.json.gz": "bb",
+		"b/part-2.json.gz": "c",
+		"b/part-3.json":    "d",
+		"b/part-4.csv.gz":  "e",
+	} {
+		path := filepath.Join(dir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+	url := func(file string) string {
+		return "file://" + filepath.Join(dir, file)
+	}
+	validate := func(entries ...ManifestEntry) error {
+		data, err := json.Marshal(Manifest{Entries: entries})
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "m.manifest"), data, 0644))
+		return ValidateManifest(LocalStorage{}, url("m.manifest"))
+	}
+
+	assert.NoError(t, validate(
+		ManifestEntry{URL: url("a/part-0.json.gz"), Mandatory: true, Meta: ManifestMeta{ContentLength: 4}},
+		ManifestEntry{URL: url("a/part-1.json.gz"), Mandatory: true},
+		ManifestEntry{URL: url("b/part-2.json.gz"), Mandatory: true},
+		// optional entries may be missing
+		ManifestEntry{URL: url("b/part-9.json.gz")},
+	))
+
+	err = validate()
+	assert.Equal(t, &ManifestError{Manifest: url("m.manifest"), Problems: []ManifestProblem{
+		{Problem: "manifest has no entries"},
+	}}, err)
+
+	err = validate(
+		ManifestEntry{URL: url("a/part-0.json.gz"), Mandatory: true, Meta: ManifestMeta{ContentLength: 5}},
+		ManifestEntry{URL: url("a/part-8.json.gz"), Mandatory: true},
+		ManifestEntry{URL: url("b/part-3.json"), Mandatory: true},
+		ManifestEntry{URL: url("b/part-4.csv.gz"), Mandatory: true},
+	)
+	assert.Equal(t, &ManifestError{Manifest: url("m.manifest"), Problems: []ManifestProblem{
+		{URL: url("a/part-0.json.gz"), Problem: "size 4 doesn't match content_length 5"},
+		{URL: url("a/part-8.json.gz"), Problem: "mandatory entry doesn't exist"},
+		{URL: url("b/part-3.json"), Problem: `compression "" doesn't match "gzip" of ` + url("a/part-0.json.gz")},
+		{URL: url("b/part-4.csv.gz"), Problem: `format "csv" doesn't match "json" of ` + url("a/part-0.json.gz")},
+	}}, err)
+	assert.Contains(t, err.Error(), "invalid manifest "+url("m.manifest")+": "+url("a/part-0.json.gz")+": size 4")
+
+	// a PathChecker that can't list sizes can't validate
+	assert.Error(t, ValidateManifest(MockPathChecker{}, "s3://b/m.manifest"))
+
+	// each directory is listed once, only as far as its entries share a name
+	lister := &prefixLister{}
+	listed, err := listEntries(lister, &Manifest{Entries: []ManifestEntry{
+		{URL: "s3://b/a/part-00.json"},
+		{URL: "s3://b/a/part-01.json"},
+		{URL: "s3://b/b/part-10.json"},
+	}})
+	assert.NoError(t, err)
+	assert.Empty(t, listed)
+	sort.Strings(lister.prefixes)
+	assert.Equal(t, []string{"s3://b/a/part-0", "s3://b/b/part-10.json"}, lister.prefixes)
+}
+
+// prefixLister records the prefixes it's asked to list, and lists nothing
+type prefixLister struct {
+	prefixes []string
+}
+
+func (pl *prefixLister) ListObjects(prefix string) ([]Object, error) {
+	pl.prefixes = append(pl.prefixes, prefix)
+	return nil, nil
+}
@@ -0,0 +1,146 @@
+package s3filepath
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// markerFile is the placeholder in a marker for the base name of the input file, e.g. {file}.done
+const markerFile = "{file}"
+
+// FileReader is implemented by PathCheckers which can also read files, so that CreateS3File can
+// check what a marker file records.
+type FileReader interface {
+	// Reader opens the file at path, which the caller must close
+	Reader(path string) (io.ReadCloser, error)
+}
+
+// markerContents is what a marker file may record about the input it marks as complete. Markers
+// that record neither, including empty ones like _SUCCESS, only need to exist.
+type markerContents struct {
+	// RecordCount is the number of records in the input, checked against the record counts of
+	// a manifest's entries
+	RecordCount *int64 `json:"record_count"`
+	// MD5 is the hex md5 checksum of the input file, i.e. of the manifest for manifests
+	MD5 string `json:"md5"`
+}
+
+// MarkerFor returns the marker file the table's input file has to have next to it, if any
+func (b S3Bucket) MarkerFor(table string) string {
+	if marker, ok := b.TableMarkers[table]; ok {
+		return marker
+	}
+	return b.Marker
+}
+
+// MarkerPath returns the path of a marker file, such as _SUCCESS or {file}.done, next to the
+// input file where the layout puts it, which is next to the parts for manifests written for them
+func (f *S3File) MarkerPath(marker string) string {
+	return markerPath(f.layoutFilename(), marker)
+}
+
+// markerPath returns the path of a marker file next to the data file at path
+func markerPath(path, marker string) string {
+	dir := strings.LastIndex(path, "/") + 1
+	return path[:dir] + strings.Replace(marker, markerFile, path[dir:], -1)
+}
+
+// CheckMarker returns a NotFoundError if the marker file isn't next to the input file, and an error
+// if the marker records something about the input that doesn't match it, see checkMarker
+func CheckMarker(pc PathChecker, f *S3File, marker string) error {
+	return checkMarker(pc, f, marker, f.Manifest != nil)
+}
+
+// checkMarker returns a NotFoundError if the marker file isn't next to the input file, since
+// the producer may not be done writing it yet, and an error if the marker records something
+// about the input that doesn't match it. For part files, which have no input file yet, what a
+// marker records can't be checked, so markers that record anything are an error.
+func checkMarker(pc PathChecker, f *S3File, marker string, parts bool) error {
+	markerPath := f.MarkerPath(marker)
+	exists, err := pc.FileExists(markerPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFound("marker file not found at: %s", markerPath)
+	}
+	fr, ok := pc.(FileReader)
+	if !ok {
+		return nil
+	}
+	data, err := readFile(fr, markerPath)
+	if err != nil {
+		return err
+	}
+	var contents markerContents
+	if len(bytes.TrimSpace(data)) == 0 || json.Unmarshal(data, &contents) != nil {
+		// markers that aren't json don't record anything we can check
+		return nil
+	}
+	if parts && (contents.RecordCount != nil || contents.MD5 != "") {
+		return fmt.Errorf("marker %s records a record count or md5, which can't be checked for part files", markerPath)
+	}
+
+	if contents.RecordCount != nil {
+		if f.Suffix != "manifest" {
+			return fmt.Errorf("marker %s has a record count, which can only be checked for manifests", markerPath)
+		}
+		manifest, err := readManifest(fr, f.GetDataFilename())
+		if err != nil {
+			return err
+		}
+		count, err := manifest.RecordCount()
+		if err != nil {
+			return fmt.Errorf("can't check marker %s: %s", markerPath, err)
+		}
+		if count != *contents.RecordCount {
+			return fmt.Errorf("marker %s records %d records but manifest %s has %d",
+				markerPath, *contents.RecordCount, f.GetDataFilename(), count)
+		}
+	}
+	if contents.MD5 != "" {
+		checksum, err := fileMD5(fr, f.GetDataFilename())
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(checksum, contents.MD5) {
+			return fmt.Errorf("marker %s records md5 %s but %s has md5 %s",
+				markerPath, contents.MD5, f.GetDataFilename(), checksum)
+		}
+	}
+	return nil
+}
+
+// fileMD5 returns the hex md5 checksum of the file at path, reading it as it's hashed rather than
+// all at once, since input files can be larger than memory
+func fileMD5(fr FileReader, path string) (string, error) {
+	reader, err := fr.Reader(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %s", path, err)
+	}
+	defer reader.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readFile(fr FileReader, path string) ([]byte, error) {
+	reader, err := fr.Reader(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %s", path, err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,91 @@
+package s3filepath
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MockStoragePathChecker serves files, which exist if they have contents
+type MockStoragePathChecker map[string]string
+
+func (ms MockStoragePathChecker) FileExists(path string) (bool, error) {
+	_, ok := ms[path]
+	return ok, nil
+}
+
+func (ms MockStoragePathChecker) ReadManifest(path string) (*Manifest, error) {
+	return readManifest(ms, path)
+}
+
+func (ms MockStoragePathChecker) Reader(path string) (io.ReadCloser, error) {
+	data, ok := ms[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+func TestMarkerPath(t *testing.T) {
+	f := S3File{Bucket: S3Bucket{Name: "b", Layout: "{schema}/{table}/{table}.{suffix}"}, Schema: "s", Table: "t", Suffix: "json"}
+	assert.Equal(t, "s3://b/s/t/_SUCCESS", f.MarkerPath("_SUCCESS"))
+	assert.Equal(t, "s3://b/s/t/t.json.done", f.MarkerPath("{file}.done"))
+
+	bucket := S3Bucket{Marker: "_SUCCESS", TableMarkers: map[string]string{"u": "{file}.done"}}
+	assert.Equal(t, "_SUCCESS", bucket.MarkerFor("t"))
+	assert.Equal(t, "{file}.done", bucket.MarkerFor("u"))
+}
+
+func TestCreateS3FileMarker(t *testing.T) {
+	manifest := `{"entries": [
+		{"url": "s3://b/s/t/part-0.gz", "mandatory": true, "meta": {"content_length": 10, "record_count": 3}},
+		{"url": "s3://b/s/t/part-1.gz", "mandatory": true, "meta": {"content_length": 10, "record_count": 4}}
+	]}`
+	sum := md5.Sum([]byte(manifest))
+	checksum := hex.EncodeToString(sum[:])
+	bucket := S3Bucket{Name: "b", Layout: "{schema}/{table}/{table}.{suffix}", Marker: "_SUCCESS"}
+
+	for _, tc := range []struct {
+		marker string
+		// missing is whether the marker file should be missing, rather than have the marker contents
+		missing bool
+		err     string
+	}{
+		{marker: ""},
+		{marker: "done"},
+		{marker: `{"record_count": 7}`},
+		{marker: fmt.Sprintf(`{"md5": "%s"}`, strings.ToUpper(checksum))},
+		{marker: fmt.Sprintf(`{"record_count": 7, "md5": "%s"}`, checksum)},
+		{missing: true, err: "marker file not found at: s3://b/s/t/_SUCCESS"},
+		{marker: `{"record_count": 6}`, err: "marker s3://b/s/t/_SUCCESS records 6 records but manifest s3://b/s/t/t.manifest has 7"},
+		{marker: `{"md5": "abc"}`, err: "marker s3://b/s/t/_SUCCESS records md5 abc but s3://b/s/t/t.manifest has md5 " + checksum},
+	} {
+		files := MockStoragePathChecker{"s3://b/s/t/t.manifest": manifest}
+		if !tc.missing {
+			files["s3://b/s/t/_SUCCESS"] = tc.marker
+		}
+		file, err := CreateS3File(files, bucket, "s", "t", "", expectedDate)
+		if tc.err == "" {
+			if assert.NoError(t, err, tc.marker) {
+				assert.Equal(t, "manifest", file.Suffix)
+			}
+		} else {
+			assert.EqualError(t, err, tc.err, tc.marker)
+		}
+	}
+	// a missing marker might still be written, so it can be waited for
+	_, err := CreateS3File(MockStoragePathChecker{"s3://b/s/t/t.manifest": manifest}, bucket, "s", "t", "", expectedDate)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	// record counts are only in manifests
+	files := MockStoragePathChecker{"s3://b/s/t/t.json": "{}", "s3://b/s/t/t.json.done": `{"record_count": 1}`}
+	_, err = CreateS3File(files, S3Bucket{Name: "b", Layout: bucket.Layout, Marker: "{file}.done"}, "s", "t", "", expectedDate)
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+package s3filepath
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultStagingPrefix is where in the bucket files written for loads, such as manifests for
+// part files, go if the bucket doesn't set its StagingPrefix
+const DefaultStagingPrefix = "_staging"
+
+// Object is a file found by listing, with its size
+type Object struct {
+	Path string
+	Size int64
+	// ETag is the file's S3 ETag, if the listing has one
+	ETag string
+}
+
+// ObjectLister is implemented by Listers which also know the sizes of the files they list, so
+// that CreateS3File can build manifests for part files.
+type ObjectLister interface {
+	// ListObjects returns the files whose path starts with prefix, with their sizes, leaving out
+	// files in directories below prefix's, so that listing a directory doesn't list its subtree
+	ListObjects(prefix string) ([]Object, error)
+}
+
+// FileWriter is implemented by PathCheckers which can also write files, so that StageManifest
+// can write manifests for part files.
+type FileWriter interface {
+	Write(path string, data []byte) error
+}
+
+// InputSize returns the size of the input file, or the total size of a manifest's entries. Entries
+// without a content_length are looked up.
+func InputSize(pc PathChecker, f S3File) (int64, error) {
+	lister, canList := pc.(ObjectLister)
+	if !canList {
+		return 0, fmt.Errorf("can't look up sizes with %T", pc)
+	}
+	if f.Suffix != "manifest" {
+		return objectSize(lister, f.GetDataFilename())
+	}
+	manifest, err := InputManifest(pc, f)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range manifest.Entries {
+		size := e.Meta.ContentLength
+		if size == 0 {
+			if size, err = objectSize(lister, e.URL); err != nil {
+				return 0, err
+			}
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// objectSize lists the file at path to find its size
+func objectSize(lister ObjectLister, path string) (int64, error) {
+	objects, err := lister.ListObjects(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, object := range objects {
+		if object.Path == path {
+			return object.Size, nil
+		}
+	}
+	return 0, notFound("s3 file not found at: %s", path)
+}
+
+// StagedPath returns where a kind of file written for loading a table's input, such as a
+// manifest, goes in the bucket's staging prefix
+func (b S3Bucket) StagedPath(kind, schema, table string, date time.Time, suffix string) string {
+	prefix := b.StagingPrefix
+	if prefix == "" {
+		prefix = DefaultStagingPrefix
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s_%s_%s.%s", b.URL(), strings.Trim(prefix, "/"), kind,
+		schema, table, schema, table, date.Format(time.RFC3339), suffix)
+}
+
+// InputManifest returns the manifest the input file is, which is read unless it was built for
+// part files and not staged yet
+func InputManifest(pc PathChecker, f S3File) (*Manifest, error) {
+	if f.Manifest != nil {
+		return f.Manifest, nil
+	}
+	mr, canRead := pc.(ManifestReader)
+	if !canRead {
+		return nil, fmt.Errorf("can't read manifests with %T", pc)
+	}
+	return mr.ReadManifest(f.GetDataFilename())
+}
+
+// StageManifest writes the manifest built for the input file's part files to its Path in the
+// bucket's staging prefix, for COPY to load. It does nothing for other input files. It's meant
+// to be called just before loading, so that finding input files never writes to the bucket.
+func StageManifest(w FileWriter, f *S3File) error {
+	if f.Manifest == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(f.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.Write(f.Path, data); err != nil {
+		return fmt.Errorf("error writing manifest %s: %s", f.Path, err)
+	}
+	return nil
+}
+
+// partsManifest lists the files in the input file's directory matching the bucket's
+// PartPattern, and returns a manifest of them. It returns a NotFoundError if there are no
+// such files.
+func partsManifest(pc PathChecker, f *S3File) (*Manifest, error) {
+	lister, canList := pc.(ObjectLister)
+	if !canList {
+		return nil, fmt.Errorf("can't build manifests for part files with %T", pc)
+	}
+	dir := f.Bucket.URL() + "/"
+	if f.Subfolder != "." {
+		dir += f.Subfolder + "/"
+	}
+	objects, err := lister.ListObjects(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifest := Manifest{}
+	f.Listed = []Object{}
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Path, dir)
+		if matched, err := path.Match(f.Bucket.PartPattern, name); err != nil {
+			return nil, fmt.Errorf("bad part pattern %s: %s", f.Bucket.PartPattern, err)
+		} else if !matched || object.Size == 0 {
+			// empty parts, like directory placeholders, have nothing to load
+			continue
+		}
+		f.Listed = append(f.Listed, object)
+		manifest.Entries = append(manifest.Entries, ManifestEntry{
+			URL:       object.Path,
+			Mandatory: true,
+			Meta:      ManifestMeta{ContentLength: object.Size},
+		})
+	}
+	if len(manifest.Entries) == 0 {
+		return nil, notFound("no part files matching %s found in %s", f.Bucket.PartPattern, dir)
+	}
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].URL < manifest.Entries[j].URL
+	})
+	return &manifest, nil
+}
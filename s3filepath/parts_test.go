@@ -0,0 +1,160 @@
+package s3filepath
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStagedPath(t *testing.T) {
+	date := time.Date(2015, 11, 10, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, "s3://b/_staging/manifests/s/t/s_t_2015-11-10T23:00:00Z.manifest",
+		S3Bucket{Name: "b"}.StagedPath("manifests", "s", "t", date, "manifest"))
+	assert.Equal(t, "s3://b/tmp/jsonpaths/s/t/s_t_2015-11-10T23:00:00Z.json",
+		S3Bucket{Name: "b", StagingPrefix: "/tmp/"}.StagedPath("jsonpaths", "s", "t", date, "json"))
+}
+
+func TestCreateS3FileParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3filepath")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	bucket := S3Bucket{Name: dir, Endpoint: LocalEndpoint, Layout: "{schema}/{table}/{yyyy}-{mm}-{dd}/{table}.{suffix}"}
+	for file, data := range map[string]string{
+		"s/t/2015-11-10/part-00001.gz": "bb",
+		"s/t/2015-11-10/part-00000.gz": "a",
+		"s/t/2015-11-10/part-00002.gz": "",
+		"s/t/2015-11-10/_SUCCESS":      "",
+		"s/t/2015-11-11/part-00000.gz": "c",
+	} {
+		path := filepath.Join(dir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+	storage := NewStorage(bucket)
+
+	// part files aren't looked for unless there's a pattern for them
+	_, err = CreateS3File(NewListingPathChecker(storage), bucket, "s", "t", "", expectedDate)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	bucket.PartPattern = "part-*"
+	bucket.Marker = "_SUCCESS"
+	file, err := CreateS3File(NewListingPathChecker(storage), bucket, "s", "t", "", expectedDate)
+	if !assert.NoError(t, err) {
+		return
+	}
+	manifestPath := "file://" + dir + "/_staging/manifests/s/t/s_t_2015-11-10T23:00:00Z.manifest"
+	assert.Equal(t, "manifest", file.Suffix)
+	assert.Equal(t, manifestPath, file.GetDataFilename())
+	assert.Equal(t, "file://"+dir+"/s/t/2015-11-10/config_t.yml", file.ConfFile)
+	assert.Equal(t, "", file.Format)
+
+	// the manifest isn't written until it's staged
+	_, err = os.Stat(filepath.Join(dir, "_staging/manifests/s/t/s_t_2015-11-10T23:00:00Z.manifest"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, StageManifest(storage, file))
+
+	// empty parts are left out, and the rest are in order
+	manifest, err := storage.ReadManifest(manifestPath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ManifestEntry{
+			{URL: "file://" + dir + "/s/t/2015-11-10/part-00000.gz", Mandatory: true, Meta: ManifestMeta{ContentLength: 1}},
+			{URL: "file://" + dir + "/s/t/2015-11-10/part-00001.gz", Mandatory: true, Meta: ManifestMeta{ContentLength: 2}},
+		}, manifest.Entries)
+	}
+
+	// parts aren't done until their marker is there
+	_, err = CreateS3File(NewListingPathChecker(storage), bucket, "s", "t", "", expectedDate.AddDate(0, 0, 1))
+	assert.IsType(t, &NotFoundError{}, err)
+	// and a marker recording what it marks can't be checked against them
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "s/t/2015-11-10/_SUCCESS"), []byte(`{"record_count": 2}`), 0644))
+	_, err = CreateS3File(NewListingPathChecker(storage), bucket, "s", "t", "", expectedDate)
+	assert.Error(t, err)
+
+	// no parts is the same as no input file
+	bucket.PartPattern = "nope-*"
+	bucket.Marker = ""
+	_, err = CreateS3File(NewListingPathChecker(storage), bucket, "s", "t", "", expectedDate)
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+func TestCreateS3FilePartsFormat(t *testing.T) {
+	files := MockObjectStorage{objects: []Object{
+		{Path: "s3://b/s/t/part-0.parquet", Size: 10},
+		{Path: "s3://b/s/t/part-1.parquet", Size: 10},
+	}, written: map[string][]byte{}}
+	bucket := S3Bucket{Name: "b", Layout: "{schema}/{table}/{table}.{suffix}", PartPattern: "part-*", StagingPrefix: "m"}
+	file, err := CreateS3File(files, bucket, "s", "t", "", expectedDate)
+	if assert.NoError(t, err) {
+		assert.Equal(t, FormatParquet, file.Format)
+		assert.Empty(t, files.written)
+		manifest, err := InputManifest(files, *file)
+		assert.NoError(t, err)
+		assert.Len(t, manifest.Entries, 2)
+
+		assert.NoError(t, StageManifest(files, file))
+		var staged Manifest
+		assert.NoError(t, json.Unmarshal(files.written["s3://b/m/manifests/s/t/s_t_2015-11-10T23:00:00Z.manifest"], &staged))
+		assert.Equal(t, *manifest, staged)
+	}
+
+	// a PathChecker that can't list sizes can't build manifests
+	_, err = CreateS3File(MockPathChecker{}, bucket, "s", "t", "", expectedDate)
+	assert.Error(t, err)
+}
+
+// MockObjectStorage lists objects and keeps what's written to it
+type MockObjectStorage struct {
+	objects []Object
+	written map[string][]byte
+}
+
+func (ms MockObjectStorage) FileExists(path string) (bool, error) {
+	return false, nil
+}
+
+func (ms MockObjectStorage) ListObjects(prefix string) ([]Object, error) {
+	return ms.objects, nil
+}
+
+func (ms MockObjectStorage) Write(path string, data []byte) error {
+	ms.written[path] = data
+	return nil
+}
+
+func TestInputSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3filepath")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.json.gz"), []byte("aaa"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.json.gz"), []byte("bbbbb"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "t.manifest"), []byte(`{"entries": [
+		{"url": "file://`+dir+`/a.json.gz", "mandatory": true},
+		{"url": "file://`+dir+`/b.json.gz", "mandatory": true, "meta": {"content_length": 10}}
+	]}`), 0644))
+	storage := NewStorage(S3Bucket{Name: dir, Endpoint: LocalEndpoint})
+
+	file := S3File{Path: "file://" + dir + "/a.json.gz", Suffix: "json.gz"}
+	size, err := InputSize(storage, file)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+
+	// entries' content_length is trusted, and the rest are looked up
+	file = S3File{Path: "file://" + dir + "/t.manifest", Suffix: "manifest"}
+	size, err = InputSize(storage, file)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(13), size)
+
+	file = S3File{Path: "file://" + dir + "/c.json.gz", Suffix: "json.gz"}
+	_, err = InputSize(storage, file)
+	assert.IsType(t, &NotFoundError{}, err)
+}
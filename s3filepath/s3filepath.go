@@ -2,16 +2,26 @@ package s3filepath
 
 import (
 	"fmt"
+	"io"
+	"path"
 	"regexp"
+	"strings"
 	"time"
-
-	"github.com/Clever/pathio"
 )
 
 var (
-	// currently assumes no unix file created timestamp
-	s3Regex   = regexp.MustCompile(".*_.*_(.*?)\\.(.*)")
 	yamlRegex = regexp.MustCompile(".*\\.yml")
+
+	// inputSuffixes are the suffixes of the input files CreateS3File looks for, in the order it tries them
+	inputSuffixes = []string{
+		"manifest", // 1) manifest file
+		"parquet",  // 2) parquet file
+		"orc",      // 3) orc file
+		"json.gz",  // 4) gzipped json file
+		"json",     // 5) json file
+		".gz",      // 6) gzipped csv file (.gz)
+		"",         // 7) csv file (no suffix when UNLOADed :-/)
+	}
 )
 
 // S3Bucket is our subset of the s3.Bucket class, useful for testing mostly
@@ -19,6 +29,24 @@ type S3Bucket struct {
 	Name            string
 	Region          string
 	RedshiftRoleARN string
+	// Layout is the template for where files are in the bucket, DefaultLayout if empty. See ValidateLayout.
+	Layout string
+	// TableLayouts overrides Layout for some tables, by table name
+	TableLayouts map[string]string
+	// Marker is a file that has to be next to the input file for it to be found, such as
+	// _SUCCESS, or {file}.done for the input file's name with .done appended. See MarkerPath.
+	Marker string
+	// TableMarkers overrides Marker for some tables, by table name
+	TableMarkers map[string]string
+	// PartPattern matches the names of part files, e.g. part-*, to write a manifest for when
+	// there's no input file. See path.Match for the syntax.
+	PartPattern string
+	// StagingPrefix is where files written for loads go, such as manifests for part files,
+	// DefaultStagingPrefix if empty. See StagedPath.
+	StagingPrefix string
+	// Endpoint is the URL of an S3 compatible store to use instead of S3, or LocalEndpoint for
+	// Name to be a local directory. See NewStorage.
+	Endpoint string
 }
 
 // S3File holds everything needed to run a COPY on the file
@@ -31,31 +59,224 @@ type S3File struct {
 	DataDate  time.Time
 	Subfolder string
 	ConfFile  string
+	// Format is set for columnar (parquet or orc) input, and left empty for
+	// JSON or CSV input, which is told apart by the delimiter instead
+	Format string
+	// Path overrides where the input file is, for files that aren't where the
+	// layout puts them, such as manifests written for part files
+	Path string
+	// Manifest is the manifest built for part files, which isn't at Path until
+	// StageManifest writes it there
+	Manifest *Manifest
+	// Listed is what listing a manifest's entries found, when building the manifest
+	// for part files or ValidateInputManifest did, so InputVersions can reuse it
+	Listed []Object
+	// JSONPaths is the path of a JSONPaths file to load JSON input with, rather
+	// than 'auto', into Columns in order
+	JSONPaths string
+	Columns   []string
+}
+
+// IsColumnar returns whether the file is in a self-describing columnar format,
+// which COPY loads with FORMAT AS rather than JSON or DELIMITER options
+func (f *S3File) IsColumnar() bool {
+	return f.Format == FormatParquet || f.Format == FormatORC
 }
 
 // PathChecker is the interface for determining if a path in S3 exists, which allows
 // DI for testing.
 type PathChecker interface {
-	FileExists(path string) bool
+	// FileExists returns whether the file exists, or an error if it couldn't tell,
+	// e.g. because of missing permissions
+	FileExists(path string) (bool, error)
 }
 
-// S3PathChecker will use pathio to determine if the path actually exists in S3, and
-// will be used in prod.
-type S3PathChecker struct{}
+// S3PathChecker is the Storage for S3, using pathio to determine if the path actually exists,
+// and will be used in prod.
+type S3PathChecker struct {
+	// Region is the region of the bucket, which is looked up to list files if empty
+	Region string
+}
 
 // FileExists looks up if the file exists in S3 using the pathio.Reader method.
-func (S3PathChecker) FileExists(path string) bool {
-	reader, err := pathio.Reader(path)
-	if reader != nil {
-		defer reader.Close()
+func (pc S3PathChecker) FileExists(path string) (bool, error) {
+	return fileExists(pc, path)
+}
+
+// ListingPathChecker tells if files exist from a listing of their directory, so that looking
+// for several candidate files in the same directory takes a single LIST, rather than a GET for
+// each candidate. Listings are kept for the life of the ListingPathChecker.
+type ListingPathChecker struct {
+	lister   Lister
+	listings map[string]map[string]bool
+}
+
+// NewListingPathChecker returns a ListingPathChecker using lister, which is usually an S3PathChecker
+func NewListingPathChecker(lister Lister) *ListingPathChecker {
+	return &ListingPathChecker{lister: lister, listings: map[string]map[string]bool{}}
+}
+
+// FileExists looks up if the file is in the listing of its directory, listing it if needed
+func (pc *ListingPathChecker) FileExists(path string) (bool, error) {
+	dir := path[:strings.LastIndex(path, "/")+1]
+	files, ok := pc.listings[dir]
+	if !ok {
+		// only the directory itself is needed, not everything below it
+		var listed []string
+		var err error
+		if ol, canList := pc.lister.(ObjectLister); canList {
+			listed, err = objectPaths(ol.ListObjects(dir))
+		} else {
+			listed, err = pc.lister.ListFiles(dir)
+		}
+		if err != nil {
+			return false, err
+		}
+		files = map[string]bool{}
+		for _, f := range listed {
+			files[f] = true
+		}
+		pc.listings[dir] = files
 	}
-	return err == nil
+	return files[path], nil
+}
+
+// ReadManifest reads the manifest at path with the lister if it can, or else using pathio
+func (pc *ListingPathChecker) ReadManifest(path string) (*Manifest, error) {
+	if mr, ok := pc.lister.(ManifestReader); ok {
+		return mr.ReadManifest(path)
+	}
+	return S3PathChecker{}.ReadManifest(path)
+}
+
+// Reader opens the file at path with the lister if it can, or else using pathio
+func (pc *ListingPathChecker) Reader(path string) (io.ReadCloser, error) {
+	if fr, ok := pc.lister.(FileReader); ok {
+		return fr.Reader(path)
+	}
+	return S3PathChecker{}.Reader(path)
+}
+
+// ListObjects lists the files under prefix with their sizes, if the lister can
+func (pc *ListingPathChecker) ListObjects(prefix string) ([]Object, error) {
+	if ol, ok := pc.lister.(ObjectLister); ok {
+		return ol.ListObjects(prefix)
+	}
+	return nil, fmt.Errorf("can't list sizes of files with %T", pc.lister)
+}
+
+// Write writes the file at path with the lister if it can, or else using pathio
+func (pc *ListingPathChecker) Write(path string, data []byte) error {
+	if fw, ok := pc.lister.(FileWriter); ok {
+		return fw.Write(path, data)
+	}
+	return S3PathChecker{}.Write(path, data)
+}
+
+// ReadManifest reads and parses the manifest file at path using pathio.
+func (pc S3PathChecker) ReadManifest(path string) (*Manifest, error) {
+	return readManifest(pc, path)
+}
+
+// createPartsS3File returns an S3File for a manifest of the part files in the input file's
+// directory, which is staged in the bucket's staging prefix by StageManifest
+func createPartsS3File(pc PathChecker, bucket S3Bucket, schema, table, subfolder, confFile string, date time.Time) (*S3File, error) {
+	inputFile := S3File{
+		Bucket:    bucket,
+		Schema:    schema,
+		Table:     table,
+		Suffix:    "manifest",
+		DataDate:  date,
+		Subfolder: subfolder,
+		ConfFile:  confFile,
+	}
+	// the parts need to be done before writing a manifest of them
+	if marker := bucket.MarkerFor(table); marker != "" {
+		if err := checkMarker(pc, &inputFile, marker, true); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := partsManifest(pc, &inputFile)
+	if err != nil {
+		return nil, err
+	}
+	inputFile.Path = bucket.StagedPath("manifests", schema, table, date, "manifest")
+	inputFile.Manifest = manifest
+	if inputFile.Format, err = manifest.Format(); err != nil {
+		return nil, fmt.Errorf("part files in %s: %s", subfolder, err)
+	}
+	return &inputFile, nil
+}
+
+// SourceS3File returns an S3File for an input file at source, such as one a load ledger recorded
+// loading before, rather than looking for it where the layout puts it. Its config is where the layout
+// puts configs for the date, unless suppliedConf is set.
+func SourceS3File(pc PathChecker, bucket S3Bucket, schema, table, suppliedConf string, date time.Time, source string) (*S3File, error) {
+	layout := bucket.LayoutFor(table)
+	if err := ValidateLayout(layout); err != nil {
+		return nil, err
+	}
+	inputFile := S3File{
+		Bucket:    bucket,
+		Schema:    schema,
+		Table:     table,
+		DataDate:  date,
+		Subfolder: path.Dir(renderLayout(layout, schema, table, date, "")),
+		ConfFile:  fmt.Sprintf("%s/%s", bucket.URL(), configPath(layout, schema, table, date)),
+		Path:      source,
+	}
+	if suppliedConf != "" {
+		inputFile.ConfFile = suppliedConf
+	}
+	// the last suffix is empty, so one always matches
+	for _, suffix := range inputSuffixes {
+		if strings.HasSuffix(source, suffix) {
+			inputFile.Suffix = suffix
+			inputFile.Format = formatForPath(suffix)
+			break
+		}
+	}
+
+	exists, err := pc.FileExists(source)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, notFound("s3 file not found at: %s", source)
+	}
+	if mr, ok := pc.(ManifestReader); ok && inputFile.Suffix == "manifest" {
+		manifest, err := mr.ReadManifest(source)
+		if err != nil {
+			return nil, err
+		}
+		if inputFile.Format, err = manifest.Format(); err != nil {
+			return nil, fmt.Errorf("manifest %s: %s", source, err)
+		}
+	}
+	return &inputFile, nil
+}
+
+func isInputSuffix(suffix string) bool {
+	for _, s := range inputSuffixes {
+		if s == suffix {
+			return true
+		}
+	}
+	return false
 }
 
 // GetDataFilename returns the s3 filepath associated with an S3File
 // 3useful for redshift COPY commands, amongst other things
 func (f *S3File) GetDataFilename() string {
-	return fmt.Sprintf("s3://%s/%s/%s_%s_%s.%s", f.Bucket.Name, f.Subfolder, f.Schema, f.Table, f.DataDate.Format(time.RFC3339), f.Suffix)
+	if f.Path != "" {
+		return f.Path
+	}
+	return f.layoutFilename()
+}
+
+// layoutFilename returns where the layout puts the input file
+func (f *S3File) layoutFilename() string {
+	return fmt.Sprintf("%s/%s", f.Bucket.URL(), renderLayout(f.Bucket.LayoutFor(f.Table), f.Schema, f.Table, f.DataDate, f.Suffix))
 }
 
 // CreateS3File creates an S3File object with either a supplied config
@@ -63,25 +284,54 @@ func (f *S3File) GetDataFilename() string {
 func CreateS3File(pc PathChecker, bucket S3Bucket, schema, table, suppliedConf string, date time.Time) (*S3File, error) {
 	// set configuration location
 	formattedDate := date.Format(time.RFC3339)
-	subfolder := fmt.Sprintf("%s/%s/_data_timestamp_year=%02d/_data_timestamp_month=%02d/_data_timestamp_day=%02d",
-		schema, table, date.Year(), int(date.Month()), date.Day())
-	confFile := fmt.Sprintf("s3://%s/%s/config_%s_%s_%s.yml", bucket.Name, subfolder, schema, table, formattedDate)
+	layout := bucket.LayoutFor(table)
+	if err := ValidateLayout(layout); err != nil {
+		return nil, err
+	}
+	subfolder := path.Dir(renderLayout(layout, schema, table, date, ""))
+	confFile := fmt.Sprintf("%s/%s", bucket.URL(), configPath(layout, schema, table, date))
 	if suppliedConf != "" {
 		confFile = suppliedConf
 	}
 	// Try to find manifest or data files out of the following patterns, in order
-	// we try to get in order as otherwise
-	for _, suffix := range []string{
-		"manifest", // 1) manifest file
-		"json.gz",  // 2) gzipped json file
-		"json",     // 3) json file
-		".gz",      // 4) gzipped csv file (.gz)
-		""} {       // 5) csv file (no suffix when UNLOADed :-/)
-		inputFile := S3File{bucket, schema, table, suffix, date, subfolder, confFile}
-		if pc.FileExists(inputFile.GetDataFilename()) {
-			return &inputFile, nil
+	for _, suffix := range inputSuffixes {
+		inputFile := S3File{
+			Bucket:    bucket,
+			Schema:    schema,
+			Table:     table,
+			Suffix:    suffix,
+			DataDate:  date,
+			Subfolder: subfolder,
+			ConfFile:  confFile,
+			Format:    formatForPath(suffix),
+		}
+		exists, err := pc.FileExists(inputFile.GetDataFilename())
+		if err != nil {
+			return nil, err
 		}
+		if !exists {
+			continue
+		}
+		// manifests hide the format of the files they point at, so peek inside if we can
+		if mr, ok := pc.(ManifestReader); ok && suffix == "manifest" {
+			manifest, err := mr.ReadManifest(inputFile.GetDataFilename())
+			if err != nil {
+				return nil, err
+			}
+			if inputFile.Format, err = manifest.Format(); err != nil {
+				return nil, fmt.Errorf("manifest %s: %s", inputFile.GetDataFilename(), err)
+			}
+		}
+		if marker := bucket.MarkerFor(table); marker != "" {
+			if err := checkMarker(pc, &inputFile, marker, false); err != nil {
+				return nil, err
+			}
+		}
+		return &inputFile, nil
+	}
+	if bucket.PartPattern != "" {
+		return createPartsS3File(pc, bucket, schema, table, subfolder, confFile, date)
 	}
-	return nil, fmt.Errorf("s3 file not found at: bucket: %s schema: %s, table: %s date: %s",
+	return nil, notFound("s3 file not found at: bucket: %s schema: %s, table: %s date: %s",
 		bucket.Name, schema, table, formattedDate)
 }
@@ -14,7 +14,7 @@ var (
 )
 
 func getTestFileWithResults(b, s, t, r, arn, subfolder, confFile, suf string, date time.Time) S3File {
-	bucket := S3Bucket{b, r, arn}
+	bucket := S3Bucket{Name: b, Region: r, RedshiftRoleARN: arn}
 	s3File := S3File{
 		Bucket:    bucket,
 		Schema:    s,
@@ -32,8 +32,22 @@ type MockPathChecker struct {
 }
 
 // use a mock path checker that has all the paths to make sure that
-func (mp MockPathChecker) FileExists(path string) bool {
-	return mp.ExistingPaths[path]
+func (mp MockPathChecker) FileExists(path string) (bool, error) {
+	return mp.ExistingPaths[path], nil
+}
+
+// MockManifestPathChecker additionally serves manifests
+type MockManifestPathChecker struct {
+	MockPathChecker
+	Manifests map[string]Manifest
+}
+
+func (mp MockManifestPathChecker) ReadManifest(path string) (*Manifest, error) {
+	m, ok := mp.Manifests[path]
+	if !ok {
+		return nil, errors.New("manifest not found")
+	}
+	return &m, nil
 }
 
 func TestCreateS3File(t *testing.T) {
@@ -51,7 +65,7 @@ func TestCreateS3File(t *testing.T) {
 	// test completely non-existent file
 	expFile := getTestFileWithResults(bucket, schema, table, region, redshiftRoleARN, expFolder, expConf, "json.gz", expectedDate)
 	returnedFile, err := CreateS3File(MockPathChecker{}, expFile.Bucket, schema, "bad_table", "", expectedDate)
-	assert.Equal(t, errors.New("s3 file not found at: bucket: b schema: s, table: bad_table date: 2015-11-10T23:00:00Z"), err)
+	assert.Equal(t, &NotFoundError{msg: "s3 file not found at: bucket: b schema: s, table: bad_table date: 2015-11-10T23:00:00Z"}, err)
 
 	// test generated json gzip conf file
 	expFile = getTestFileWithResults(bucket, schema, table, region, redshiftRoleARN, expFolder, expConf, "json.gz", expectedDate)
@@ -95,3 +109,137 @@ func TestCreateS3File(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, expFile, *returnedFile)
 }
+
+func TestCreateS3FileColumnar(t *testing.T) {
+	bucket, schema, table, region, redshiftRoleARN := "b", "s", "t", "r", "arn"
+	expFolder := "s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10"
+	expConf := "s3://b/" + expFolder + "/config_s_t_2015-11-10T23:00:00Z.yml"
+	parquetPath := "s3://b/" + expFolder + "/s_t_2015-11-10T23:00:00Z.parquet"
+	orcPath := "s3://b/" + expFolder + "/s_t_2015-11-10T23:00:00Z.orc"
+	jsonPath := "s3://b/" + expFolder + "/s_t_2015-11-10T23:00:00Z.json"
+	manifestPath := "s3://b/" + expFolder + "/s_t_2015-11-10T23:00:00Z.manifest"
+
+	// parquet is preferred over json
+	expFile := getTestFileWithResults(bucket, schema, table, region, redshiftRoleARN, expFolder, expConf, "parquet", expectedDate)
+	expFile.Format = FormatParquet
+	testFiles := map[string]bool{parquetPath: true, jsonPath: true}
+	returnedFile, err := CreateS3File(MockPathChecker{testFiles}, expFile.Bucket, schema, table, "", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, expFile, *returnedFile)
+
+	expFile = getTestFileWithResults(bucket, schema, table, region, redshiftRoleARN, expFolder, expConf, "orc", expectedDate)
+	expFile.Format = FormatORC
+	testFiles = map[string]bool{orcPath: true}
+	returnedFile, err = CreateS3File(MockPathChecker{testFiles}, expFile.Bucket, schema, table, "", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, expFile, *returnedFile)
+
+	// manifests take the format of their entries
+	expFile = getTestFileWithResults(bucket, schema, table, region, redshiftRoleARN, expFolder, expConf, "manifest", expectedDate)
+	expFile.Format = FormatParquet
+	pc := MockManifestPathChecker{
+		MockPathChecker: MockPathChecker{map[string]bool{manifestPath: true}},
+		Manifests: map[string]Manifest{manifestPath: {Entries: []ManifestEntry{
+			{URL: "s3://b/part-00000.parquet", Mandatory: true},
+			{URL: "s3://b/part-00001.parquet", Mandatory: true},
+		}}},
+	}
+	returnedFile, err = CreateS3File(pc, expFile.Bucket, schema, table, "", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, expFile, *returnedFile)
+
+	// but can't mix them
+	pc.Manifests[manifestPath] = Manifest{Entries: []ManifestEntry{
+		{URL: "s3://b/part-00000.parquet"},
+		{URL: "s3://b/part-00001.json.gz"},
+	}}
+	_, err = CreateS3File(pc, expFile.Bucket, schema, table, "", expectedDate)
+	assert.Error(t, err)
+}
+
+func TestSourceS3File(t *testing.T) {
+	bucket, schema, table, region, redshiftRoleARN := "b", "s", "t", "r", "arn"
+	expFolder := "s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10"
+	expConf := "s3://b/" + expFolder + "/config_s_t_2015-11-10T23:00:00Z.yml"
+	// a file loaded before needn't be where the layout puts it
+	source := "s3://b/_staging/manifests/s/t/s_t_2015-11-10T23:00:00Z.manifest"
+
+	expFile := getTestFileWithResults(bucket, schema, table, region, redshiftRoleARN, expFolder, expConf, "manifest", expectedDate)
+	expFile.Format = FormatParquet
+	expFile.Path = source
+	pc := MockManifestPathChecker{
+		MockPathChecker: MockPathChecker{map[string]bool{source: true}},
+		Manifests: map[string]Manifest{source: {Entries: []ManifestEntry{
+			{URL: "s3://b/part-00000.parquet", Mandatory: true},
+		}}},
+	}
+	returnedFile, err := SourceS3File(pc, expFile.Bucket, schema, table, "", expectedDate, source)
+	assert.NoError(t, err)
+	assert.Equal(t, expFile, *returnedFile)
+
+	csvSource := "s3://b/" + expFolder + "/s_t_2015-11-10T23:00:00Z.gz"
+	pc.ExistingPaths[csvSource] = true
+	returnedFile, err = SourceS3File(pc, expFile.Bucket, schema, table, "foo", expectedDate, csvSource)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ".gz", returnedFile.Suffix)
+		assert.Equal(t, "", returnedFile.Format)
+		assert.Equal(t, "foo", returnedFile.ConfFile)
+	}
+
+	// the file may have been deleted since
+	_, err = SourceS3File(pc, expFile.Bucket, schema, table, "", expectedDate, "s3://b/gone.json")
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+// CountingLister counts how often it lists
+type CountingLister struct {
+	MockLister
+	Lists int
+}
+
+func (cl *CountingLister) ListFiles(prefix string) ([]string, error) {
+	cl.Lists++
+	return cl.MockLister.ListFiles(prefix)
+}
+
+func TestListingPathChecker(t *testing.T) {
+	lister := &CountingLister{MockLister: MockLister{[]string{
+		"s3://b/s/t/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/s_t_2015-11-10T23:00:00Z.json.gz",
+		"s3://b/s/u/_data_timestamp_year=2015/_data_timestamp_month=11/_data_timestamp_day=10/s_u_2015-11-10T23:00:00Z.json",
+	}}}
+	pc := NewListingPathChecker(lister)
+
+	// all the suffixes are probed from one listing
+	f, err := CreateS3File(pc, S3Bucket{Name: "b"}, "s", "t", "", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, "json.gz", f.Suffix)
+	assert.Equal(t, 1, lister.Lists)
+
+	f, err = CreateS3File(pc, S3Bucket{Name: "b"}, "s", "u", "", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, "json", f.Suffix)
+	assert.Equal(t, 2, lister.Lists)
+
+	_, err = CreateS3File(pc, S3Bucket{Name: "b"}, "s", "t", "", expectedDate)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lister.Lists)
+}
+
+func TestListingPathCheckerErrors(t *testing.T) {
+	// a failed listing isn't a missing file
+	_, err := CreateS3File(NewListingPathChecker(ErrLister{}), S3Bucket{Name: "b"}, "s", "t", "", expectedDate)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "access denied")
+		assert.NotContains(t, err.Error(), "not found")
+	}
+}
+
+func TestS3PathCheckerLocal(t *testing.T) {
+	// pathio reads local paths too, which shows missing files aren't errors
+	exists, err := S3PathChecker{}.FileExists("s3filepath_test.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = S3PathChecker{}.FileExists("nope.json")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
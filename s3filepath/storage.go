@@ -0,0 +1,357 @@
+package s3filepath
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Clever/pathio"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// LocalEndpoint is the S3Bucket endpoint for a local directory, for development without S3
+const LocalEndpoint = "file://"
+
+// Storage is where a bucket's input files and configs are kept, which lets the worker run against
+// S3, an S3 compatible store such as minio, or a local directory, and allows DI for testing.
+type Storage interface {
+	PathChecker
+	Lister
+	ObjectLister
+	ManifestReader
+	FileReader
+	FileWriter
+	Versioner
+	// BucketRegion returns the region of the bucket, for redshift to COPY from
+	BucketRegion(bucket string) (string, error)
+}
+
+// NewStorage returns the Storage for the bucket's endpoint: S3 if it has none, an S3 compatible
+// store at the endpoint if it has one, or the local directory the bucket names for LocalEndpoint
+func NewStorage(bucket S3Bucket) Storage {
+	switch bucket.Endpoint {
+	case "":
+		return S3PathChecker{Region: bucket.Region}
+	case LocalEndpoint:
+		return LocalStorage{}
+	}
+	return EndpointStorage{Endpoint: bucket.Endpoint}
+}
+
+// ConfReader returns what reads the input file's config, which --config can put outside the
+// bucket: s3:// paths are read from S3, or the bucket's endpoint if it has one, and any other
+// path from disk
+func (f S3File) ConfReader() FileReader {
+	if !strings.HasPrefix(f.ConfFile, "s3://") {
+		return LocalStorage{}
+	}
+	if f.Bucket.Endpoint == LocalEndpoint {
+		return S3PathChecker{}
+	}
+	return NewStorage(f.Bucket)
+}
+
+// URL returns the base URL of the files in the bucket, e.g. s3://bucket
+func (b S3Bucket) URL() string {
+	if b.Endpoint == LocalEndpoint {
+		return LocalEndpoint + b.Name
+	}
+	return "s3://" + b.Name
+}
+
+// fileExists opens path to tell if it exists, treating only a missing file as not existing
+func fileExists(fr FileReader, path string) (bool, error) {
+	reader, err := fr.Reader(path)
+	if reader != nil {
+		defer reader.Close()
+	}
+	if isNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error checking for %s: %s", path, err)
+	}
+	return true, nil
+}
+
+// isNotExist tells if err is because a file doesn't exist, in S3 or on disk
+func isNotExist(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		// HEAD requests have no body to say NoSuchKey in
+		return aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound"
+	}
+	return os.IsNotExist(err)
+}
+
+// readManifest opens and parses the manifest file at path
+func readManifest(fr FileReader, path string) (*Manifest, error) {
+	reader, err := fr.Reader(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening manifest %s: %s", path, err)
+	}
+	defer reader.Close()
+	return ParseManifest(reader)
+}
+
+// clients caches S3 clients by region or endpoint, and the regions of buckets, so that looking
+// through many files, such as a manifest's entries, sets up a session and finds the region once
+var clients = struct {
+	sync.Mutex
+	byKey   map[string]*s3.S3
+	regions map[string]string
+}{byKey: map[string]*s3.S3{}, regions: map[string]string{}}
+
+// cachedClient returns the client cached under key, creating it with config if there isn't one
+func cachedClient(key string, config *aws.Config) *s3.S3 {
+	clients.Lock()
+	defer clients.Unlock()
+	client, ok := clients.byKey[key]
+	if !ok {
+		client = s3.New(session.New(), config)
+		clients.byKey[key] = client
+	}
+	return client
+}
+
+// listObjects lists the files under prefix with client, which must be able to reach the bucket.
+// Unless recursive, files in directories below prefix's are left out, which S3 does for us when
+// listing with a delimiter, rather than paging through them.
+func listObjects(client *s3.S3, prefix string, recursive bool) ([]Object, error) {
+	bucket, keyPrefix, err := splitS3Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(keyPrefix),
+	}
+	if !recursive {
+		input.Delimiter = aws.String("/")
+	}
+	var objects []Object
+	err = client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, Object{
+				Path: fmt.Sprintf("s3://%s/%s", bucket, *object.Key),
+				Size: aws.Int64Value(object.Size),
+				ETag: strings.Trim(aws.StringValue(object.ETag), `"`),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %s", prefix, err)
+	}
+	return objects, nil
+}
+
+// objectPaths returns the paths of objects, for Listers that list them with their sizes
+func objectPaths(objects []Object, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(objects))
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	return paths, nil
+}
+
+// bucketRegion looks up the region of the bucket with client, which must use path style
+func bucketRegion(client *s3.S3, name string) (string, error) {
+	resp, err := client.GetBucketLocation(&s3.GetBucketLocationInput{
+		Bucket: aws.String(name),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get location for bucket '%s', %s", name, err)
+	}
+	if resp.LocationConstraint == nil || *resp.LocationConstraint == "" {
+		// "US Standard", returns an empty region. So return any region in the US
+		return "us-east-1", nil
+	}
+	return *resp.LocationConstraint, nil
+}
+
+// Reader opens the file at path with pathio, which reads s3 paths and local ones
+func (S3PathChecker) Reader(path string) (io.ReadCloser, error) {
+	return pathio.Reader(path)
+}
+
+// Write writes the file at path with pathio
+func (S3PathChecker) Write(path string, data []byte) error {
+	return pathio.Write(path, data)
+}
+
+// BucketRegion looks up the region of the bucket in S3
+func (S3PathChecker) BucketRegion(name string) (string, error) {
+	// Any region will work for the region lookup, but the request MUST use
+	// PathStyle
+	config := aws.NewConfig().WithRegion("us-west-1").WithS3ForcePathStyle(true)
+	return bucketRegion(s3.New(session.New(), config), name)
+}
+
+// EndpointStorage is an S3 compatible store, such as minio, at a custom endpoint. Buckets are
+// addressed in the path rather than the host name, which is what such stores usually expect.
+// Credentials come from the environment, as they do for S3.
+type EndpointStorage struct {
+	// Endpoint is the URL of the store, e.g. http://localhost:9000
+	Endpoint string
+}
+
+func (es EndpointStorage) client() *s3.S3 {
+	// the region is only used to sign requests, which most compatible stores ignore
+	config := aws.NewConfig().
+		WithEndpoint(es.Endpoint).
+		WithRegion("us-east-1").
+		WithS3ForcePathStyle(true)
+	return cachedClient(es.Endpoint, config)
+}
+
+// FileExists looks up if the file exists at the endpoint
+func (es EndpointStorage) FileExists(path string) (bool, error) {
+	return fileExists(es, path)
+}
+
+// ListFiles lists the files under prefix at the endpoint, including those in directories below it
+func (es EndpointStorage) ListFiles(prefix string) ([]string, error) {
+	return objectPaths(listObjects(es.client(), prefix, true))
+}
+
+// ListObjects lists the files under prefix at the endpoint that are in its directory, with their sizes
+func (es EndpointStorage) ListObjects(prefix string) ([]Object, error) {
+	return listObjects(es.client(), prefix, false)
+}
+
+// ReadManifest reads and parses the manifest file at path from the endpoint
+func (es EndpointStorage) ReadManifest(path string) (*Manifest, error) {
+	return readManifest(es, path)
+}
+
+// Reader opens the s3 path from the endpoint, or reads a local path from disk, as pathio does
+func (es EndpointStorage) Reader(path string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(path, "s3://") {
+		return os.Open(path)
+	}
+	bucket, key, err := splitS3Path(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := es.client().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Write writes the file at path to the endpoint
+func (es EndpointStorage) Write(path string, data []byte) error {
+	bucket, key, err := splitS3Path(path)
+	if err != nil {
+		return err
+	}
+	_, err = es.client().PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// BucketRegion looks up the region of the bucket at the endpoint
+func (es EndpointStorage) BucketRegion(name string) (string, error) {
+	return bucketRegion(es.client(), name)
+}
+
+// LocalStorage reads a bucket from a local directory, for development. Its paths are file://
+// URLs, but plain local paths can be read too, e.g. for a supplied config.
+type LocalStorage struct{}
+
+func localPath(path string) string {
+	return strings.TrimPrefix(path, LocalEndpoint)
+}
+
+// FileExists looks up if the file exists on disk
+func (ls LocalStorage) FileExists(path string) (bool, error) {
+	return fileExists(ls, path)
+}
+
+// ListFiles lists the files on disk whose path starts with prefix, including those in directories
+// below it, which is an empty listing rather than an error if the prefix's directory doesn't
+// exist, like S3
+func (LocalStorage) ListFiles(prefix string) ([]string, error) {
+	return objectPaths(listLocal(prefix, true))
+}
+
+// ListObjects lists the files on disk whose path starts with prefix that are in its directory,
+// with their sizes
+func (LocalStorage) ListObjects(prefix string) ([]Object, error) {
+	return listLocal(prefix, false)
+}
+
+// listLocal lists the files on disk whose path starts with prefix, leaving out those in
+// directories below prefix's unless recursive, as listObjects does
+func listLocal(prefix string, recursive bool) ([]Object, error) {
+	if strings.HasPrefix(prefix, "s3://") {
+		return nil, fmt.Errorf("can't list %s, which isn't a local path", prefix)
+	}
+	local := localPath(prefix)
+	dir := local
+	if !strings.HasSuffix(local, "/") {
+		dir = filepath.Dir(local)
+	}
+	var objects []Object
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() && !recursive && filepath.Clean(file) != filepath.Clean(dir) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasPrefix(file, local) {
+			objects = append(objects, Object{Path: LocalEndpoint + file, Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %s", prefix, err)
+	}
+	return objects, nil
+}
+
+// ReadManifest reads and parses the manifest file at path from disk
+func (ls LocalStorage) ReadManifest(path string) (*Manifest, error) {
+	return readManifest(ls, path)
+}
+
+// Reader opens the file at path on disk
+func (LocalStorage) Reader(path string) (io.ReadCloser, error) {
+	return os.Open(localPath(path))
+}
+
+// Write writes the file at path on disk, making its directory if needed
+func (LocalStorage) Write(path string, data []byte) error {
+	local := localPath(path)
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(local, data, 0644)
+}
+
+// BucketRegion returns no region, since a local directory isn't in one. Redshift can't COPY from
+// a local directory anyway, so it's only good for dry runs and inferring configs.
+func (LocalStorage) BucketRegion(name string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,150 @@
+package s3filepath
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStorage(t *testing.T) {
+	assert.Equal(t, S3PathChecker{Region: "us-west-1"}, NewStorage(S3Bucket{Name: "b", Region: "us-west-1"}))
+	assert.Equal(t, EndpointStorage{Endpoint: "http://localhost:9000"}, NewStorage(S3Bucket{Name: "b", Endpoint: "http://localhost:9000"}))
+	assert.Equal(t, LocalStorage{}, NewStorage(S3Bucket{Name: "/tmp/b", Endpoint: LocalEndpoint}))
+
+	// configs are read by their path, wherever the bucket is
+	for conf, reader := range map[string]FileReader{
+		"s3://c/config.yml":        S3PathChecker{},
+		"file:///tmp/c/config.yml": LocalStorage{},
+		"config.yml":               LocalStorage{},
+	} {
+		assert.Equal(t, reader, S3File{Bucket: S3Bucket{Name: "/tmp/b", Endpoint: LocalEndpoint}, ConfFile: conf}.ConfReader(), conf)
+	}
+	assert.Equal(t, EndpointStorage{Endpoint: "http://localhost:9000"},
+		S3File{Bucket: S3Bucket{Name: "b", Endpoint: "http://localhost:9000"}, ConfFile: "s3://b/config.yml"}.ConfReader())
+	assert.Equal(t, S3PathChecker{Region: "us-west-1"}, S3File{Bucket: S3Bucket{Name: "b", Region: "us-west-1"}, ConfFile: "s3://b/config.yml"}.ConfReader())
+
+	assert.Equal(t, "s3://b", S3Bucket{Name: "b", Endpoint: "http://localhost:9000"}.URL())
+	assert.Equal(t, "file:///tmp/b", S3Bucket{Name: "/tmp/b", Endpoint: LocalEndpoint}.URL())
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3filepath")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	bucket := S3Bucket{Name: dir, Endpoint: LocalEndpoint, Layout: "{schema}/{table}/{yyyy}-{mm}-{dd}.{suffix}"}
+	manifest := `{"entries": [{"url": "s3://b/part-0.parquet", "mandatory": true}]}`
+	for file, data := range map[string]string{
+		"s/t/2015-11-09.json":       "{}",
+		"s/t/2015-11-10.manifest":   manifest,
+		"s/t/config_2015-11-10.yml": "",
+	} {
+		path := filepath.Join(dir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+	storage := NewStorage(bucket)
+
+	date, err := LatestDataDate(storage, bucket, "s", "t", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2015, 11, 10, 0, 0, 0, 0, time.UTC), date)
+
+	file, err := CreateS3File(NewListingPathChecker(storage), bucket, "s", "t", "", date)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "file://"+dir+"/s/t/2015-11-10.manifest", file.GetDataFilename())
+		assert.Equal(t, "file://"+dir+"/s/t/config_2015-11-10.yml", file.ConfFile)
+		assert.Equal(t, FormatParquet, file.Format)
+	}
+
+	exists, err := storage.FileExists("file://" + dir + "/s/t/2015-11-11.json")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// like S3, listing a prefix nothing is under finds nothing rather than failing
+	files, err := storage.ListFiles("file://" + dir + "/s/u/")
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	_, err = storage.ListFiles("s3://b/s/t/")
+	assert.Error(t, err)
+
+	// listing a directory leaves out the directories below it
+	objects, err := storage.ListObjects("file://" + dir + "/s/")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+	files, err = storage.ListFiles("file://" + dir + "/s/")
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+}
+
+func TestEndpointStorage(t *testing.T) {
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "secret"} {
+		old, set := os.LookupEnv(key)
+		os.Setenv(key, value)
+		if set {
+			defer os.Setenv(key, old)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+	var paths, delimiters []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/b" && r.URL.Query().Get("list-type") == "2" {
+			delimiters = append(delimiters, r.URL.Query().Get("delimiter"))
+			w.Write([]byte(`<ListBucketResult><Name>b</Name><IsTruncated>false</IsTruncated>` +
+				`<Contents><Key>s/t/data.json</Key><Size>2</Size><ETag>"99914b932bd37a50b983c5e7c90ae93b"</ETag></Contents></ListBucketResult>`))
+			return
+		}
+		if _, ok := r.URL.Query()["versioning"]; r.URL.Path == "/b" && ok {
+			w.Write([]byte(`<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`))
+			return
+		}
+		if r.URL.Path == "/b/s/t/data.json" {
+			w.Header().Set("ETag", `"99914b932bd37a50b983c5e7c90ae93b"`)
+			w.Header().Set("x-amz-version-id", "v1")
+			w.Write([]byte("{}"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+	}))
+	defer server.Close()
+	storage := EndpointStorage{Endpoint: server.URL}
+
+	exists, err := storage.FileExists("s3://b/s/t/data.json")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = storage.FileExists("s3://b/s/t/nope.json")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	// buckets are addressed in the path, not the host name
+	assert.Equal(t, []string{"/b/s/t/data.json", "/b/s/t/nope.json"}, paths)
+
+	version, err := storage.FileVersion("s3://b/s/t/data.json")
+	assert.NoError(t, err)
+	assert.Equal(t, FileVersion{URL: "s3://b/s/t/data.json", ETag: "99914b932bd37a50b983c5e7c90ae93b", VersionID: "v1"}, version)
+	_, err = storage.FileVersion("s3://b/s/t/nope.json")
+	assert.True(t, isNotExist(err))
+	versioned, err := storage.Versioned("s3://b/s/t/data.json")
+	assert.NoError(t, err)
+	assert.True(t, versioned)
+	// the client is set up once
+	assert.True(t, storage.client() == storage.client())
+
+	// listing a directory leaves out the directories below it, but finding the latest file can't
+	objects, err := storage.ListObjects("s3://b/s/t/")
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{{Path: "s3://b/s/t/data.json", Size: 2, ETag: "99914b932bd37a50b983c5e7c90ae93b"}}, objects)
+	files, err := storage.ListFiles("s3://b/s/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"s3://b/s/t/data.json"}, files)
+	assert.Equal(t, []string{"/", ""}, delimiters)
+}
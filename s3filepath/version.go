@@ -0,0 +1,191 @@
+package s3filepath
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// FileVersion identifies the contents of a loaded file, so that loading the same contents again
+// can be told apart from loading new ones
+type FileVersion struct {
+	URL string `json:"url"`
+	// ETag is the S3 ETag of the file, which is the md5 of its contents for local files
+	ETag string `json:"etag"`
+	// VersionID is the S3 version of the file, if its bucket is versioned
+	VersionID string `json:"version_id,omitempty"`
+}
+
+// Versioner is implemented by PathCheckers which can tell versions of a file apart, so that
+// files which were loaded before can be skipped.
+type Versioner interface {
+	FileVersion(path string) (FileVersion, error)
+	// Versioned returns whether the bucket of the file at path keeps versions of its files,
+	// which listing it doesn't tell
+	Versioned(path string) (bool, error)
+}
+
+// InputVersions returns the versions of the files that loading the input file loads: the file
+// itself, or the entries of a manifest. Optional entries that don't exist are left out. Entries
+// take their ETags from listing their directories, as the input file's Listed or listed here,
+// and are only looked up one by one for their versions in versioned buckets.
+func InputVersions(pc PathChecker, f S3File) ([]FileVersion, error) {
+	versioner, ok := pc.(Versioner)
+	if !ok {
+		return nil, fmt.Errorf("can't look up file versions with %T", pc)
+	}
+	if f.Suffix != "manifest" {
+		version, err := versioner.FileVersion(f.GetDataFilename())
+		if err != nil {
+			return nil, err
+		}
+		return []FileVersion{version}, nil
+	}
+	manifest, err := InputManifest(pc, f)
+	if err != nil {
+		return nil, err
+	}
+	listed := f.Listed
+	if lister, canList := pc.(ObjectLister); canList && listed == nil {
+		if listed, err = listEntries(lister, manifest); err != nil {
+			return nil, err
+		}
+	}
+	objects := map[string]Object{}
+	for _, object := range listed {
+		objects[object.Path] = object
+	}
+	versioned := map[string]bool{}
+	var versions []FileVersion
+	for _, e := range manifest.Entries {
+		object, found := objects[e.URL]
+		if listed != nil && !found {
+			if e.Mandatory {
+				return nil, fmt.Errorf("mandatory manifest entry %s doesn't exist", e.URL)
+			}
+			continue
+		}
+		keepsVersions, err := bucketVersioned(versioner, e.URL, versioned)
+		if err != nil {
+			return nil, err
+		}
+		if found && object.ETag != "" && !keepsVersions {
+			versions = append(versions, FileVersion{URL: e.URL, ETag: object.ETag})
+			continue
+		}
+		version, err := versioner.FileVersion(e.URL)
+		if isNotExist(err) && !e.Mandatory {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// bucketVersioned returns whether the bucket of the file at path keeps versions, looking each
+// bucket up once in known
+func bucketVersioned(versioner Versioner, path string, known map[string]bool) (bool, error) {
+	bucket := path
+	if name, _, err := splitS3Path(path); err == nil {
+		bucket = name
+	}
+	if versioned, ok := known[bucket]; ok {
+		return versioned, nil
+	}
+	versioned, err := versioner.Versioned(path)
+	if err != nil {
+		return false, err
+	}
+	known[bucket] = versioned
+	return versioned, nil
+}
+
+// bucketVersioning looks up whether the bucket of the file at path has versioning with client,
+// which must be able to reach it. Suspended versioning still keeps the versions written before.
+func bucketVersioning(client *s3.S3, path string) (bool, error) {
+	bucket, _, err := splitS3Path(path)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return false, fmt.Errorf("error getting the versioning of bucket %s: %s", bucket, err)
+	}
+	return aws.StringValue(resp.Status) != "", nil
+}
+
+// headVersion looks up the version of the file at path with client, which must be able to reach its bucket
+func headVersion(client *s3.S3, path string) (FileVersion, error) {
+	bucket, key, err := splitS3Path(path)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	resp, err := client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return FileVersion{}, err
+	}
+	version := FileVersion{URL: path, ETag: strings.Trim(aws.StringValue(resp.ETag), `"`)}
+	// objects written before versioning was turned on have the version "null"
+	if v := aws.StringValue(resp.VersionId); v != "null" {
+		version.VersionID = v
+	}
+	return version, nil
+}
+
+// FileVersion looks up the ETag and version of the file in S3
+func (pc S3PathChecker) FileVersion(path string) (FileVersion, error) {
+	client, err := pc.client(path)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	return headVersion(client, path)
+}
+
+// Versioned looks up whether the bucket of the file in S3 has versioning
+func (pc S3PathChecker) Versioned(path string) (bool, error) {
+	client, err := pc.client(path)
+	if err != nil {
+		return false, err
+	}
+	return bucketVersioning(client, path)
+}
+
+// FileVersion looks up the ETag and version of the file at the endpoint
+func (es EndpointStorage) FileVersion(path string) (FileVersion, error) {
+	return headVersion(es.client(), path)
+}
+
+// Versioned looks up whether the bucket of the file at the endpoint has versioning
+func (es EndpointStorage) Versioned(path string) (bool, error) {
+	return bucketVersioning(es.client(), path)
+}
+
+// FileVersion returns the md5 of the file on disk as its ETag, as S3 has for files that aren't
+// uploaded in parts
+func (ls LocalStorage) FileVersion(path string) (FileVersion, error) {
+	reader, err := ls.Reader(path)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	defer reader.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return FileVersion{}, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return FileVersion{URL: path, ETag: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Versioned is false, since files on disk have no versions
+func (LocalStorage) Versioned(path string) (bool, error) {
+	return false, nil
+}
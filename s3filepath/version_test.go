@@ -0,0 +1,94 @@
+package s3filepath
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3filepath")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.json.gz"), []byte("{}"), 0644))
+	writeManifest := func(mandatory bool) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "t.manifest"), []byte(fmt.Sprintf(`{"entries": [
+			{"url": "file://%s/a.json.gz", "mandatory": true},
+			{"url": "file://%s/b.json.gz", "mandatory": %t}
+		]}`, dir, dir, mandatory)), 0644))
+	}
+	storage := NewStorage(S3Bucket{Name: dir, Endpoint: LocalEndpoint})
+	a := FileVersion{URL: "file://" + dir + "/a.json.gz", ETag: "99914b932bd37a50b983c5e7c90ae93b"}
+
+	versions, err := InputVersions(storage, S3File{Path: a.URL, Suffix: "json.gz"})
+	assert.NoError(t, err)
+	assert.Equal(t, []FileVersion{a}, versions)
+
+	// optional entries that aren't there aren't loaded
+	writeManifest(false)
+	manifest := S3File{Path: "file://" + dir + "/t.manifest", Suffix: "manifest"}
+	versions, err = InputVersions(storage, manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, []FileVersion{a}, versions)
+
+	writeManifest(true)
+	_, err = InputVersions(storage, manifest)
+	assert.Error(t, err)
+
+	_, err = InputVersions(MockPathChecker{}, manifest)
+	assert.Error(t, err)
+}
+
+func TestInputVersionsListed(t *testing.T) {
+	manifest := &Manifest{Entries: []ManifestEntry{
+		{URL: "s3://b/part-0.json", Mandatory: true},
+		{URL: "s3://b/part-1.json", Mandatory: true},
+		{URL: "s3://b/part-2.json"},
+	}}
+	listed := []Object{{Path: "s3://b/part-0.json", ETag: "e0"}, {Path: "s3://b/part-1.json", ETag: "e1"}}
+	f := S3File{Suffix: "manifest", Manifest: manifest, Listed: listed}
+
+	// ETags come from the listing, without looking up each file
+	storage := &headCounter{}
+	versions, err := InputVersions(storage, f)
+	assert.NoError(t, err)
+	assert.Equal(t, []FileVersion{{URL: "s3://b/part-0.json", ETag: "e0"}, {URL: "s3://b/part-1.json", ETag: "e1"}}, versions)
+	assert.Empty(t, storage.heads)
+	assert.Equal(t, 1, storage.versioningLookups)
+
+	// unless the bucket keeps versions, which only looking them up tells
+	storage = &headCounter{versioned: true}
+	versions, err = InputVersions(storage, f)
+	assert.NoError(t, err)
+	assert.Equal(t, []FileVersion{{URL: "s3://b/part-0.json", ETag: "e0", VersionID: "v"}, {URL: "s3://b/part-1.json", ETag: "e1", VersionID: "v"}}, versions)
+	assert.Equal(t, []string{"s3://b/part-0.json", "s3://b/part-1.json"}, storage.heads)
+
+	// mandatory entries the listing didn't find are an error
+	f.Listed = listed[:1]
+	_, err = InputVersions(&headCounter{}, f)
+	assert.Error(t, err)
+}
+
+// headCounter is a Versioner that records the files it's asked for the versions of
+type headCounter struct {
+	MockPathChecker
+	versioned         bool
+	versioningLookups int
+	heads             []string
+}
+
+func (hc *headCounter) FileVersion(path string) (FileVersion, error) {
+	hc.heads = append(hc.heads, path)
+	return FileVersion{URL: path, ETag: "e" + path[len(path)-6:len(path)-5], VersionID: "v"}, nil
+}
+
+func (hc *headCounter) Versioned(path string) (bool, error) {
+	hc.versioningLookups++
+	return hc.versioned, nil
+}
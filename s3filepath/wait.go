@@ -0,0 +1,67 @@
+package s3filepath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NotFoundError is returned when a table's input files aren't in the bucket, which they may be
+// later, as opposed to when they couldn't be looked for
+type NotFoundError struct {
+	msg string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.msg
+}
+
+func notFound(format string, a ...interface{}) error {
+	return &NotFoundError{msg: fmt.Sprintf(format, a...)}
+}
+
+// DataNotArrivedError is returned by WaitFor when the input files still aren't there at the timeout
+type DataNotArrivedError struct {
+	Waited time.Duration
+	Err    error
+}
+
+func (e *DataNotArrivedError) Error() string {
+	return fmt.Sprintf("data not arrived after waiting %s: %s", e.Waited, e.Err)
+}
+
+func (e *DataNotArrivedError) Unwrap() error {
+	return e.Err
+}
+
+// WaitFor calls find every interval for as long as it returns a NotFoundError, wrapped or not,
+// up to timeout, when it returns a DataNotArrivedError. The last call is at the timeout, even if
+// that's sooner than interval. Any other error is returned right away, as is ctx's error if it's
+// done while waiting. With a timeout of 0, find is only called once and its NotFoundError
+// returned as is.
+func WaitFor(ctx context.Context, timeout, interval time.Duration, find func() error) error {
+	start := time.Now()
+	for {
+		err := find()
+		var notFound *NotFoundError
+		if !errors.As(err, &notFound) || timeout <= 0 {
+			return err
+		}
+		waited := time.Since(start)
+		if waited >= timeout {
+			return &DataNotArrivedError{Waited: waited, Err: err}
+		}
+		wait := interval
+		if left := timeout - waited; left < wait {
+			wait = left
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("stopped waiting for input data after %s: %w", waited, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
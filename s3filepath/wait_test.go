@@ -0,0 +1,78 @@
+package s3filepath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitFor(t *testing.T) {
+	// found after a couple of polls
+	calls := 0
+	err := WaitFor(context.Background(), time.Second, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return notFound("not yet")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// other errors aren't waited out
+	calls = 0
+	err = WaitFor(context.Background(), time.Second, time.Millisecond, func() error {
+		calls++
+		return errors.New("access denied")
+	})
+	assert.EqualError(t, err, "access denied")
+	assert.Equal(t, 1, calls)
+
+	// never found, even when wrapped
+	err = WaitFor(context.Background(), 5*time.Millisecond, time.Millisecond, func() error {
+		return fmt.Errorf("issue parsing date: %w", notFound("no input files found"))
+	})
+	var notArrived *DataNotArrivedError
+	if assert.True(t, errors.As(err, &notArrived)) {
+		assert.Contains(t, err.Error(), "no input files found")
+	}
+
+	// without a timeout, not found is returned as is
+	err = WaitFor(context.Background(), 0, time.Millisecond, func() error {
+		return notFound("not yet")
+	})
+	assert.IsType(t, &NotFoundError{}, err)
+
+	// the last poll is at the timeout, even when the interval is longer
+	calls = 0
+	err = WaitFor(context.Background(), 10*time.Millisecond, time.Hour, func() error {
+		calls++
+		return notFound("not yet")
+	})
+	assert.True(t, errors.As(err, &notArrived))
+	assert.Equal(t, 2, calls)
+
+	// the worker being stopped ends the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WaitFor(ctx, time.Hour, time.Hour, func() error {
+		return notFound("not yet")
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.As(err, &notArrived))
+}
+
+func TestCheckMarker(t *testing.T) {
+	file, err := CreateS3File(MockPathChecker{map[string]bool{"s3://b/s/t/t.json": true}},
+		S3Bucket{Name: "b", Layout: "{schema}/{table}/{table}.{suffix}"}, "s", "t", "", expectedDate)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "s3://b/s/t/_SUCCESS", file.MarkerPath("_SUCCESS"))
+	assert.NoError(t, CheckMarker(MockPathChecker{map[string]bool{"s3://b/s/t/_SUCCESS": true}}, file, "_SUCCESS"))
+	assert.IsType(t, &NotFoundError{}, CheckMarker(MockPathChecker{}, file, "_SUCCESS"))
+}